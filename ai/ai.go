@@ -13,7 +13,7 @@ import (
 )
 
 type AI interface {
-	StartChat(ctx context.Context, systemInstruction string) error
+	StartChat(ctx context.Context, systemInstruction string, opts ...ChatOption) error
 	Send(ctx context.Context, input string) (Result, error)
 }
 
@@ -24,15 +24,31 @@ type Result interface {
 }
 
 var OpenAICli openai.Client
+var LocalCli openai.Client
 var GeminiClis []*genai.Client
 
 func Init() {
 	discord.Infof("Initializing AI clients")
 	if config.TheConfig.OpenAI != "" {
 		discord.Infof("Initializing OpenAI")
-		OpenAICli = openai.NewClient(
-			option.WithAPIKey(config.TheConfig.OpenAI),
+		opts := []option.RequestOption{option.WithAPIKey(config.TheConfig.OpenAI)}
+		if config.TheConfig.OpenAIBaseURL != "" {
+			opts = append(opts, option.WithBaseURL(config.TheConfig.OpenAIBaseURL))
+		}
+		OpenAICli = openai.NewClient(opts...)
+		Register(ProviderOpenAI, func() []AI {
+			return []AI{NewOpenAI(OpenAICli, config.TheConfig.OpenAIModel)}
+		})
+	}
+	if config.TheConfig.LocalAIBaseURL != "" && config.TheConfig.LocalAIModel != "" {
+		discord.Infof("Initializing local AI at %s", config.TheConfig.LocalAIBaseURL)
+		LocalCli = openai.NewClient(
+			option.WithAPIKey(config.TheConfig.LocalAIKey),
+			option.WithBaseURL(config.TheConfig.LocalAIBaseURL),
 		)
+		Register(ProviderLocal, func() []AI {
+			return []AI{NewOpenAI(LocalCli, config.TheConfig.LocalAIModel)}
+		})
 	}
 	if len(config.TheConfig.Gemini) > 0 {
 		for _, g := range config.TheConfig.Gemini {
@@ -47,7 +63,15 @@ func Init() {
 			}
 			GeminiClis = append(GeminiClis, cli)
 		}
+		Register(ProviderGemini, func() []AI {
+			clients := make([]AI, 0, len(GeminiClis))
+			for _, cli := range GeminiClis {
+				clients = append(clients, NewGemini(cli))
+			}
+			return clients
+		})
 	}
+	checkChain()
 }
 
 func limit(input []string, limit int) error {
@@ -59,20 +83,30 @@ func limit(input []string, limit int) error {
 
 func SendWithRetrySplit(ctx context.Context, systemMessage string,
 	inputs []string, pass func(input string, result Result) bool, timelinesCounter func(input string) int,
-	postProcessor func(input string) string) ([]string, error) {
+	postProcessor func(input string) string, opts ...ChatOption) ([]string, error) {
 	err := limit(inputs, 15)
 	if err != nil {
 		return nil, err
 	}
 
+	options := applyOptions(opts)
+	checkpoint := options.checkpoint
+
 	run := func(a AI) ([]string, error) {
 		var translated []string
 
-		err = a.StartChat(ctx, systemMessage)
+		err = a.StartChat(ctx, systemMessage, opts...)
 		if err != nil {
 			return nil, err
 		}
 		for idx, input := range inputs {
+			if checkpoint != nil {
+				if output, ok := checkpoint.Load(input); ok {
+					discord.Infof("Processing index: %d/%d, restored from checkpoint", idx, len(inputs)-1)
+					translated = append(translated, output)
+					continue
+				}
+			}
 			inputLines := timelinesCounter(input)
 			discord.Infof("Processing index: %d/%d, Input length: %d, Input timelines: %d",
 				idx, len(inputs)-1, len(input), inputLines)
@@ -80,28 +114,54 @@ func SendWithRetrySplit(ctx context.Context, systemMessage string,
 			if err != nil || result == nil {
 				return nil, err
 			}
-			translated = append(translated, postProcessor(result.Text()))
+			output := postProcessor(result.Text())
+			if checkpoint != nil {
+				if err := checkpoint.Save(input, output); err != nil {
+					discord.Errorf("Error saving checkpoint: %v", err)
+				}
+			}
+			if options.progress != nil {
+				options.progress(input, output)
+			}
+			translated = append(translated, output)
 		}
 		return translated, nil
 	}
 
-	exhausted := 0
-	for i, cli := range GeminiClis {
+	clients := chain()
+	if len(clients) == 0 {
+		return nil, fmt.Errorf("no AI provider configured in %v", config.TheConfig.AiProvider)
+	}
+	for _, c := range clients {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		if !coolingUntil(c.name).IsZero() {
+			continue
+		}
 		var res []string
-		res, err = run(NewGemini(cli))
+		res, err = run(&pooled{AI: c.ai, key: c.name})
 		if err == nil {
 			return res, nil
 		}
-		discord.Errorf("Cli %d failed with error: %+v", i, err)
-		if isErrorExhausted(err) {
-			exhausted++
+		discord.Errorf("Cli %s failed with error: %+v", c.name, err)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	// no client answered, the ones that didn't cool down failed for good, so waiting for the first cooled down
+	// client is the only way left unless none is cooling down
+	var until time.Time
+	for _, c := range clients {
+		cooling := coolingUntil(c.name)
+		if !cooling.IsZero() && (until.IsZero() || cooling.Before(until)) {
+			until = cooling
 		}
 	}
-	if exhausted == len(GeminiClis) {
-		discord.Errorf("All clients exhausted, sleeping for 1 hour")
-		time.Sleep(1 * time.Hour)
+	if until.IsZero() {
+		return nil, err
 	}
-	return nil, err
+	return nil, &ExhaustedError{Until: until}
 }
 
 func SendWithRetry(ctx context.Context, a AI, input string, pass func(input string, result Result) bool) (Result, error) {
@@ -109,6 +169,9 @@ func SendWithRetry(ctx context.Context, a AI, input string, pass func(input stri
 	var attempted []Result
 	attempts := config.TheConfig.TranslationAttempts
 	for i := 1; i < attempts+1; i++ {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
 		discord.Infof("Attempt: %d", i)
 		result, err := a.Send(ctx, input)
 		if err != nil {
@@ -116,7 +179,7 @@ func SendWithRetry(ctx context.Context, a AI, input string, pass func(input stri
 			if result != nil && result.Response() != nil && utils.AsJson(result.Response()) != "null" {
 				fmt.Println(utils.AsJson(result.Response()))
 			}
-			if isErrorExhausted(err) {
+			if isErrorExhausted(err) || isErrorUnavailable(err) {
 				return result, err
 			}
 		} else {
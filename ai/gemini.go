@@ -7,8 +7,6 @@ import (
 	"context"
 	"fmt"
 	"google.golang.org/genai"
-	"strings"
-	"time"
 )
 
 type gemini struct {
@@ -44,18 +42,19 @@ func (g *geminiResponse) Response() interface{} {
 	return g.response
 }
 
-func (g *gemini) StartChat(ctx context.Context, systemInstruction string) error {
-	chat, err := g.client.Chats.Create(ctx, config.TheConfig.GeminiModel, &genai.GenerateContentConfig{
-		SystemInstruction: genai.NewContentFromText(systemInstruction, genai.RoleUser)},
-		[]*genai.Content{})
+func (g *gemini) StartChat(ctx context.Context, systemInstruction string, opts ...ChatOption) error {
+	o := applyOptions(opts)
+	generateConfig := &genai.GenerateContentConfig{
+		SystemInstruction: genai.NewContentFromText(systemInstruction, genai.RoleUser)}
+	if o.schema != nil {
+		generateConfig.ResponseMIMEType = "application/json"
+		generateConfig.ResponseJsonSchema = o.schema
+	}
+	chat, err := g.client.Chats.Create(ctx, config.TheConfig.GeminiModel, generateConfig, []*genai.Content{})
 	g.chat = chat
 	return err
 }
 
-func isErrorExhausted(err error) bool {
-	return strings.Contains(err.Error(), "RESOURCE_EXHAUSTED")
-}
-
 func (g *gemini) Send(ctx context.Context, input string) (Result, error) {
 	discord.Infof("Sending to Gemini %s", config.TheConfig.GeminiModel)
 
@@ -65,13 +64,6 @@ func (g *gemini) Send(ctx context.Context, input string) (Result, error) {
 	resp, err := g.chat.SendMessage(ctx, genai.Part{Text: input})
 	result := &geminiResponse{response: resp}
 	if err != nil {
-		if isErrorExhausted(err) {
-			return result, err
-		}
-		if strings.Contains(err.Error(), "try again later") {
-			discord.Errorf("Gemini unavaialble, sleeping..., %v", err)
-			time.Sleep(15 * time.Minute)
-		}
 		return result, err
 	}
 	if resp == nil || len(resp.Candidates) == 0 || len(resp.Candidates[0].Content.Parts) == 0 {
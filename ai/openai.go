@@ -1,29 +1,38 @@
 package ai
 
 import (
-	"Sparkle/config"
 	"Sparkle/discord"
 	"Sparkle/utils"
 	"context"
 	"fmt"
 	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
 )
 
 type openaiTranslator struct {
-	messages []openai.ChatCompletionMessageParamUnion
+	client         openai.Client
+	model          string
+	messages       []openai.ChatCompletionMessageParamUnion
+	responseFormat openai.ChatCompletionNewParamsResponseFormatUnion
 }
 
 type openaiResponse struct {
 	response *openai.ChatCompletion
 }
 
-func NewOpenAI() AI {
+// NewOpenAI chats with model through client, which may point at any OpenAI-compatible server
+func NewOpenAI(client openai.Client, model string) AI {
 	return &openaiTranslator{
+		client:   client,
+		model:    model,
 		messages: make([]openai.ChatCompletionMessageParamUnion, 0),
 	}
 }
 
 func (r *openaiResponse) Usage() interface{} {
+	if r.response == nil {
+		return nil
+	}
 	return r.response.Usage
 }
 
@@ -38,15 +47,25 @@ func (r *openaiResponse) Response() interface{} {
 	return r.response
 }
 
-func (o *openaiTranslator) StartChat(_ context.Context, systemInstruction string) error {
+func (o *openaiTranslator) StartChat(_ context.Context, systemInstruction string, opts ...ChatOption) error {
 	o.messages = []openai.ChatCompletionMessageParamUnion{
 		openai.SystemMessage(systemInstruction),
 	}
+	o.responseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{}
+	if options := applyOptions(opts); options.schema != nil {
+		o.responseFormat.OfJSONSchema = &shared.ResponseFormatJSONSchemaParam{
+			JSONSchema: shared.ResponseFormatJSONSchemaJSONSchemaParam{
+				Name:   options.schemaName,
+				Schema: options.schema,
+				Strict: openai.Bool(true),
+			},
+		}
+	}
 	return nil
 }
 
 func (o *openaiTranslator) Send(ctx context.Context, input string) (Result, error) {
-	discord.Infof("Sending to OpenAI %s", config.TheConfig.OpenAIModel)
+	discord.Infof("Sending to OpenAI %s", o.model)
 
 	if len(o.messages) == 0 {
 		return nil, fmt.Errorf("chat not started, call StartChat first")
@@ -55,9 +74,10 @@ func (o *openaiTranslator) Send(ctx context.Context, input string) (Result, erro
 	// Add a user message to the conversation history
 	o.messages = append(o.messages, openai.UserMessage(input))
 
-	resp, err := OpenAICli.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
-		Model:    config.TheConfig.OpenAIModel,
-		Messages: o.messages,
+	resp, err := o.client.Chat.Completions.New(ctx, openai.ChatCompletionNewParams{
+		Model:          o.model,
+		Messages:       o.messages,
+		ResponseFormat: o.responseFormat,
 	})
 	result := &openaiResponse{response: resp}
 	if err != nil {
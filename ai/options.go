@@ -0,0 +1,47 @@
+package ai
+
+// chatOptions are the optional settings of a chat, applied by StartChat
+type chatOptions struct {
+	schemaName string
+	schema     map[string]interface{}
+	checkpoint Checkpoint
+	progress   func(input, output string)
+}
+
+// Checkpoint keeps the output of inputs that already went through, SendWithRetrySplit skips them on a re-run
+type Checkpoint interface {
+	Load(input string) (string, bool)
+	Save(input, output string) error
+}
+
+type ChatOption func(o *chatOptions)
+
+// WithJSONSchema makes the model answer with JSON matching schema, name identifies the schema towards OpenAI
+func WithJSONSchema(name string, schema map[string]interface{}) ChatOption {
+	return func(o *chatOptions) {
+		o.schemaName = name
+		o.schema = schema
+	}
+}
+
+// WithCheckpoint makes SendWithRetrySplit reuse and record the outputs kept by cp
+func WithCheckpoint(cp Checkpoint) ChatOption {
+	return func(o *chatOptions) {
+		o.checkpoint = cp
+	}
+}
+
+// WithProgress makes SendWithRetrySplit call fn with every output as soon as it is received
+func WithProgress(fn func(input, output string)) ChatOption {
+	return func(o *chatOptions) {
+		o.progress = fn
+	}
+}
+
+func applyOptions(opts []ChatOption) chatOptions {
+	var o chatOptions
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return o
+}
@@ -0,0 +1,139 @@
+package ai
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/openai/openai-go"
+	"google.golang.org/genai"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ExhaustedError is returned when every client of the chain is cooling down, callers can retry after Until
+type ExhaustedError struct {
+	Until time.Time
+}
+
+func (e *ExhaustedError) Error() string {
+	return fmt.Sprintf("all AI providers exhausted until %s", e.Until.Format(time.RFC3339))
+}
+
+// KeyStats is what the pool recorded for one client of the chain
+type KeyStats struct {
+	Requests      int64
+	Tokens        int64
+	CooldownUntil time.Time
+}
+
+var pool = struct {
+	sync.Mutex
+	keys map[string]*KeyStats
+}{keys: make(map[string]*KeyStats)}
+
+// Stats returns a copy of the usage recorded per client, keyed like "gemini 0"
+func Stats() map[string]KeyStats {
+	pool.Lock()
+	defer pool.Unlock()
+	stats := make(map[string]KeyStats, len(pool.keys))
+	for key, s := range pool.keys {
+		stats[key] = *s
+	}
+	return stats
+}
+
+func keyStats(key string) *KeyStats {
+	s, ok := pool.keys[key]
+	if !ok {
+		s = &KeyStats{}
+		pool.keys[key] = s
+	}
+	return s
+}
+
+// coolingUntil returns the cooldown deadline of a client, zero when it is healthy
+func coolingUntil(key string) time.Time {
+	pool.Lock()
+	defer pool.Unlock()
+	s := keyStats(key)
+	if time.Now().After(s.CooldownUntil) {
+		return time.Time{}
+	}
+	return s.CooldownUntil
+}
+
+func coolDown(key string, d time.Duration, err error) {
+	pool.Lock()
+	defer pool.Unlock()
+	until := time.Now().Add(d)
+	keyStats(key).CooldownUntil = until
+	discord.Errorf("Cli %s cooling down until %s: %v", key, until.Format(time.RFC3339), err)
+}
+
+func recordUsage(key string, result Result) {
+	pool.Lock()
+	defer pool.Unlock()
+	s := keyStats(key)
+	s.Requests++
+	s.Tokens += usageTokens(result)
+}
+
+// usageTokens reads the total token count of a result, zero when the provider didn't report one
+func usageTokens(result Result) int64 {
+	if result == nil {
+		return 0
+	}
+	switch u := result.Usage().(type) {
+	case *genai.GenerateContentResponseUsageMetadata:
+		if u != nil {
+			return int64(u.TotalTokenCount)
+		}
+	case openai.CompletionUsage:
+		return u.TotalTokens
+	}
+	return 0
+}
+
+// isErrorExhausted tells whether a provider refused for quota, Gemini reports RESOURCE_EXHAUSTED and
+// OpenAI-compatible servers answer 429
+func isErrorExhausted(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusTooManyRequests {
+		return true
+	}
+	return strings.Contains(err.Error(), "RESOURCE_EXHAUSTED")
+}
+
+// isErrorUnavailable tells whether a provider is overloaded or down for a while
+func isErrorUnavailable(err error) bool {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusServiceUnavailable {
+		return true
+	}
+	return strings.Contains(err.Error(), "try again later") || strings.Contains(err.Error(), "UNAVAILABLE")
+}
+
+// pooled records the requests of a client and puts it on cooldown when its provider refuses
+type pooled struct {
+	AI
+	key string
+}
+
+func (p *pooled) Send(ctx context.Context, input string) (Result, error) {
+	result, err := p.AI.Send(ctx, input)
+	if err == nil {
+		recordUsage(p.key, result)
+		return result, nil
+	}
+	recordUsage(p.key, nil)
+	if isErrorExhausted(err) {
+		coolDown(p.key, config.TheConfig.AiExhaustedCooldown, err)
+	} else if isErrorUnavailable(err) {
+		coolDown(p.key, config.TheConfig.AiUnavailableCooldown, err)
+	}
+	return result, err
+}
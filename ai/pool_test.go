@@ -0,0 +1,81 @@
+package ai
+
+import (
+	"Sparkle/config"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type exhaustedAI struct{ sent *int }
+
+func (e exhaustedAI) StartChat(context.Context, string, ...ChatOption) error { return nil }
+
+func (e exhaustedAI) Send(context.Context, string) (Result, error) {
+	*e.sent++
+	return nil, fmt.Errorf("Error 429, RESOURCE_EXHAUSTED")
+}
+
+type brokenAI struct{}
+
+func (b brokenAI) StartChat(context.Context, string, ...ChatOption) error { return nil }
+
+func (b brokenAI) Send(context.Context, string) (Result, error) {
+	return nil, fmt.Errorf("invalid model")
+}
+
+func TestBrokenClientDefersToCoolingOnes(t *testing.T) {
+	config.TheConfig.TranslationAttempts = 1
+	config.TheConfig.AiExhaustedCooldown = time.Hour
+	sent := 0
+	Register("quota", func() []AI { return []AI{exhaustedAI{&sent}} })
+	Register("broken", func() []AI { return []AI{brokenAI{}} })
+	send := func() error {
+		_, err := SendWithRetrySplit(context.Background(), "", []string{"input"},
+			func(string, Result) bool { return true },
+			func(string) int { return 1 },
+			func(s string) string { return s })
+		return err
+	}
+
+	config.TheConfig.AiProvider = []string{"broken"}
+	var exhausted *ExhaustedError
+	if err := send(); err == nil || errors.As(err, &exhausted) {
+		t.Fatalf("Expected the plain error without any client cooling down, got %v", err)
+	}
+	config.TheConfig.AiProvider = []string{"quota", "broken"}
+	if err := send(); !errors.As(err, &exhausted) {
+		t.Errorf("Expected an exhausted error when the last client failed for another reason, got %v", err)
+	}
+}
+
+func TestExhaustedChainCoolsDown(t *testing.T) {
+	config.TheConfig.AiProvider = []string{"fake"}
+	config.TheConfig.TranslationAttempts = 3
+	config.TheConfig.AiExhaustedCooldown = time.Hour
+	sent := 0
+	Register("fake", func() []AI { return []AI{exhaustedAI{&sent}, exhaustedAI{&sent}} })
+
+	send := func() error {
+		_, err := SendWithRetrySplit(context.Background(), "", []string{"input"},
+			func(string, Result) bool { return true },
+			func(string) int { return 1 },
+			func(s string) string { return s })
+		return err
+	}
+	var exhausted *ExhaustedError
+	if err := send(); !errors.As(err, &exhausted) || time.Until(exhausted.Until) < 59*time.Minute {
+		t.Fatalf("Expected an exhausted error an hour out, got %v", err)
+	}
+	if sent != 2 {
+		t.Errorf("Expected one request per key, got %d", sent)
+	}
+	if err := send(); !errors.As(err, &exhausted) || sent != 2 {
+		t.Errorf("Keys cooling down were used again: %v, %d requests", err, sent)
+	}
+	if stats := Stats()["fake 1"]; stats.Requests != 1 || stats.CooldownUntil.IsZero() {
+		t.Errorf("Unexpected stats: %+v", stats)
+	}
+}
@@ -0,0 +1,59 @@
+package ai
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"fmt"
+	"strings"
+)
+
+const (
+	ProviderGemini = "gemini"
+	ProviderOpenAI = "openai"
+	ProviderLocal  = "local" // any OpenAI-compatible server such as llama.cpp, Ollama or vLLM
+)
+
+// provider creates a fresh chat for every configured client of a provider
+type provider func() []AI
+
+var providers = make(map[string]provider)
+
+// Register makes a provider available to the fallback chain under name
+func Register(name string, p func() []AI) {
+	providers[strings.ToLower(name)] = p
+}
+
+// chainEntry is one client of the fallback chain
+type chainEntry struct {
+	name string
+	ai   AI
+}
+
+// chain returns the clients of the providers in config.AiProvider in order, providers without clients are skipped
+func chain() []chainEntry {
+	var entries []chainEntry
+	for _, name := range config.TheConfig.AiProvider {
+		name = strings.ToLower(strings.TrimSpace(name))
+		p, ok := providers[name]
+		if !ok {
+			continue
+		}
+		for i, a := range p() {
+			entries = append(entries, chainEntry{name: fmt.Sprintf("%s %d", name, i), ai: a})
+		}
+	}
+	return entries
+}
+
+// checkChain reports providers in the chain that are unknown or have no client configured
+func checkChain() {
+	for _, name := range config.TheConfig.AiProvider {
+		name = strings.ToLower(strings.TrimSpace(name))
+		p, ok := providers[name]
+		if !ok {
+			discord.Errorf("Unknown AI provider: %s", name)
+		} else if len(p()) == 0 {
+			discord.Errorf("AI provider %s has no client configured", name)
+		}
+	}
+}
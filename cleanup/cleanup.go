@@ -1,6 +1,7 @@
 package cleanup
 
 import (
+	"context"
 	"os"
 	"os/signal"
 	"sync"
@@ -21,6 +22,13 @@ var quitInstance = &stop{
 	isStopping: false,
 }
 
+var ctx, cancel = context.WithCancel(context.Background())
+
+// Context is cancelled as soon as a stop signal is received, long-running work should derive from it
+func Context() context.Context {
+	return ctx
+}
+
 func AddOnStopFunc(f OnStop) {
 	quitInstance.mutex.Lock()
 	defer quitInstance.mutex.Unlock()
@@ -35,6 +43,7 @@ func Stop(sig os.Signal) {
 	defer quitInstance.mutex.Unlock()
 	quitInstance.isStopping = true
 	log.Warnf("Received signal %d, terminating...", sig)
+	cancel()
 	for _, f := range quitInstance.onStopFunc {
 		f(sig)
 	}
@@ -4,6 +4,7 @@ import (
 	"Sparkle/cleanup"
 	"Sparkle/config"
 	"Sparkle/discord"
+	"Sparkle/glossary"
 	"Sparkle/job"
 	"Sparkle/utils"
 	"encoding/json"
@@ -16,7 +17,9 @@ import (
 	"math"
 	"net/http"
 	"os"
+	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -48,6 +51,26 @@ type Room struct {
 	id       string
 	Chats    []*discord.Chat `json:"chats"`
 	LastSeek time.Time       `json:"lastSeek"`
+	Clock    PlaybackClock   `json:"clock"`
+	// Threshold overrides config.TheConfig.SyncThreshold for the room when set
+	Threshold float64 `json:"threshold,omitempty"`
+	// Roles maps player ids to RoleHost, RoleModerator or RoleViewer, Policy says who controls playback
+	Roles  map[string]string `json:"roles"`
+	Muted  map[string]bool   `json:"muted,omitempty"`
+	Kicked map[string]bool   `json:"kicked,omitempty"` // refused until the room empties
+	Policy string            `json:"policy"`
+	// Positions is the last time each player reported, kept after it leaves
+	Positions map[string]float64 `json:"positions"`
+	// Current is the job the room is playing and Queue the ones after it
+	Current  string   `json:"current,omitempty"`
+	Queue    []string `json:"queue"`
+	duration float64
+	dirty    bool
+	// lastChatId is the id of the last chat sent, lastActive when the room last changed in unix ms and
+	// expired is set once expireRooms dropped the room
+	lastChatId int64
+	lastActive int64
+	expired    bool
 	VideoState
 }
 
@@ -55,18 +78,26 @@ type Player struct {
 	ws *websocket.Conn
 	VideoState
 	PlayerState
-	exited bool
+	exited   bool
+	replaced bool
+	joined   int64
 }
 
 type PlayerState struct {
-	Name        string `json:"name"`
-	Id          string `json:"id"`
-	InBg        bool   `json:"inBg,omitempty"`
-	LastSeen    int64  `json:"lastSeen"`
-	codec       string
-	audio       string
-	subtitle    string
-	DiscordUser *DiscordUser `json:"discordUser,omitempty"`
+	Name     string `json:"name"`
+	Id       string `json:"id"`
+	InBg     bool   `json:"inBg,omitempty"`
+	LastSeen int64  `json:"lastSeen"`
+	// Rtt is the round-trip time and ClockOffset how far the player's clock is ahead of the server's, in ms
+	Rtt            float64 `json:"rtt,omitempty"`
+	ClockOffset    float64 `json:"clockOffset,omitempty"`
+	Role           string  `json:"role,omitempty"`
+	Muted          bool    `json:"muted,omitempty"`
+	latencySamples int
+	codec          string
+	audio          string
+	subtitle       string
+	DiscordUser    *DiscordUser `json:"discordUser,omitempty"`
 }
 
 type DiscordUser struct {
@@ -95,6 +126,20 @@ type PlayerPayload struct {
 	Codec       string                 `json:"codec,omitempty"`
 	Audio       string                 `json:"audio,omitempty"`
 	Subtitle    string                 `json:"subtitle,omitempty"`
+	Rate        *float64               `json:"rate,omitempty"`
+	Threshold   *float64               `json:"threshold,omitempty"`
+	Target      string                 `json:"target,omitempty"` // player id a role, host, kick or mute message is about
+	Role        string                 `json:"role,omitempty"`
+	Muted       *bool                  `json:"muted,omitempty"`
+	Policy      string                 `json:"policy,omitempty"`
+	JobId       string                 `json:"jobId,omitempty"` // job a time or pause report is about, required in rooms with a queue
+	Index       *int                   `json:"index,omitempty"` // queue position to remove or move
+	To          *int                   `json:"to,omitempty"`
+	// Timestamp is the player's clock when sending, a pong also echoes the ping's Timestamp in Echo
+	// and the time it arrived in Received
+	Timestamp int64 `json:"timestamp,omitempty"`
+	Echo      int64 `json:"echo,omitempty"`
+	Received  int64 `json:"received,omitempty"`
 }
 
 type SendPayload struct {
@@ -106,6 +151,18 @@ type SendPayload struct {
 	Players   []Player               `json:"players"`
 	Timestamp int64                  `json:"timestamp"`
 	Broadcast map[string]interface{} `json:"broadcast,omitempty"`
+	Clock     *PlaybackClock         `json:"clock,omitempty"`
+	Threshold *float64               `json:"threshold,omitempty"`
+	Echo      int64                  `json:"echo,omitempty"`
+	Received  int64                  `json:"received,omitempty"`
+	Target    string                 `json:"target,omitempty"`
+	Role      string                 `json:"role,omitempty"`
+	Muted     *bool                  `json:"muted,omitempty"`
+	Policy    string                 `json:"policy,omitempty"`
+	Error     string                 `json:"error,omitempty"`
+	Rejected  string                 `json:"rejected,omitempty"` // type of the message an error answers
+	JobId     string                 `json:"jobId,omitempty"`
+	Queue     []string               `json:"queue,omitempty"`
 }
 
 func (room *Room) syncChatsToPlayerUnsafe(player *Player) {
@@ -140,15 +197,20 @@ func (player *Player) Send(message interface{}) {
 	}
 }
 
-func (player *Player) Sync(t *float64, paused *bool, firedBy *Player) {
+// Sync sends the player the room clock, the time is where the clock will be once the message arrives
+func (player *Player) Sync(room *Room, syncTime, syncPaused bool, firedBy *Player) {
 	if firedBy != nil && firedBy.InBg {
 		return
 	}
-	if t != nil {
-		player.Send(SendPayload{Type: TimeSync, Time: t, FiredBy: firedBy, Timestamp: time.Now().UnixMilli()})
+	now := time.Now().UnixMilli()
+	clock := room.Clock
+	if syncTime {
+		t := room.targetFor(player, now)
+		player.Send(SendPayload{Type: TimeSync, Time: &t, Clock: &clock, FiredBy: firedBy, Timestamp: now})
 	}
-	if paused != nil {
-		player.Send(SendPayload{Type: PauseSync, Paused: paused, FiredBy: firedBy, Timestamp: time.Now().UnixMilli()})
+	if syncPaused {
+		paused := clock.Paused
+		player.Send(SendPayload{Type: PauseSync, Paused: &paused, Clock: &clock, FiredBy: firedBy, Timestamp: now})
 	}
 }
 
@@ -193,7 +255,13 @@ func REST() {
 	if err != nil {
 		discord.Errorf("error initializing jobs: %v", err)
 	}
+	loadRooms()
 	scheduler.Every(1).Second().Do(syncPlayerStates)
+	scheduler.Every(saveInterval).Seconds().Do(saveRooms)
+	scheduler.Every(saveInterval).Seconds().Do(saveHistories)
+	scheduler.Every(1).Second().Do(advanceRooms)
+	scheduler.Every(1).Hour().Do(expireRooms)
+	scheduler.Every(pingInterval).Seconds().Do(pingPlayers)
 	scheduler.StartAsync()
 	e = echo.New()
 	e.Use(middleware.CORSWithConfig(middleware.CORSConfig{
@@ -202,6 +270,8 @@ func REST() {
 	}), middleware.GzipWithConfig(middleware.DefaultGzipConfig), middleware.Logger(), middleware.Recover())
 	routes()
 	cleanup.AddOnStopFunc(func(_ os.Signal) {
+		saveRooms()
+		saveHistories()
 		err := e.Close()
 		if err != nil {
 			return
@@ -224,18 +294,70 @@ func Exit(room *Room, player *Player) {
 		discord.Errorf("error closing websocket: %v", err)
 	}
 	delete(room.Players, player.Id)
-	if len(room.Players) == 0 {
-		room.VideoState = defaultVideoState()
+	// a player replaced by its own new connection keeps its role and the room its state
+	if !player.replaced {
+		room.leave(player)
+		if len(room.Players) == 0 {
+			room.VideoState = defaultVideoState()
+			room.Clock = newPlaybackClock()
+			room.Roles = make(map[string]string)
+			room.Muted = nil
+			room.Kicked = nil
+			room.Policy = PolicyEveryone
+		}
 	}
+	room.dirty = true
 	log.Infof("[%v] disconnected", player.Id)
 	player.exited = true
 }
 
+// progressLinger is how long a progress stream stays open once every task of its job reached 100
+const progressLinger = 30 * time.Second
+
+// finished tells whether every task in the progress of a job reached 100
+func finished(id string) bool {
+	content, err := os.ReadFile(utils.OutputJoin(id, job.ProgressFile))
+	if err != nil {
+		return false
+	}
+	progress := job.JobProgress{}
+	if json.Unmarshal(content, &progress) != nil || len(progress.Tasks) == 0 {
+		return false
+	}
+	for _, task := range progress.Tasks {
+		if task.Percent < 100 {
+			return false
+		}
+	}
+	return true
+}
+
+func validJobId(id string) bool {
+	return id != "" && id != "." && id != ".." && filepath.Base(id) == id
+}
+
 func routes() {
 	e.Static("/static", config.TheConfig.Output)
 	e.GET("/all", func(c echo.Context) error {
+		if user := c.QueryParam("user"); user != "" {
+			if !validJobId(user) {
+				return c.String(http.StatusBadRequest, "Invalid user")
+			}
+			jobs, err := userJobs(user)
+			if err != nil {
+				return err
+			}
+			return c.JSON(http.StatusOK, jobs)
+		}
 		return c.String(http.StatusOK, job.JobsCache.GetMarshalled())
 	})
+	e.GET("/history/:user", func(c echo.Context) error {
+		user := c.Param("user")
+		if !validJobId(user) {
+			return c.String(http.StatusBadRequest, "Invalid user")
+		}
+		return c.JSON(http.StatusOK, readHistory(user).sorted())
+	})
 	e.GET("/purge", func(c echo.Context) error {
 		_, err := job.JobsCache.Get(true)
 		if err != nil {
@@ -243,6 +365,118 @@ func routes() {
 		}
 		return c.String(http.StatusOK, job.JobsCache.GetMarshalled())
 	})
+	e.GET("/jobs/:id/progress", func(c echo.Context) error {
+		id := c.Param("id")
+		if !validJobId(id) {
+			return c.String(http.StatusBadRequest, "Invalid job id")
+		}
+		content, err := os.ReadFile(utils.OutputJoin(id, job.ProgressFile))
+		if err != nil {
+			return c.String(http.StatusNotFound, "No progress reported")
+		}
+		return c.JSONBlob(http.StatusOK, content)
+	})
+	e.GET("/jobs/:id/progress/ws", func(c echo.Context) error {
+		id := c.Param("id")
+		if !validJobId(id) {
+			return c.String(http.StatusBadRequest, "Invalid job id")
+		}
+		websocket.Handler(func(ws *websocket.Conn) {
+			defer func() {
+				err := ws.Close()
+				if err != nil {
+					discord.Errorf("error closing websocket: %v", err)
+				}
+			}()
+			// the client never sends anything, a failing receive means it went away
+			closed := make(chan struct{})
+			go func() {
+				defer close(closed)
+				var msg string
+				for websocket.Message.Receive(ws, &msg) == nil {
+				}
+			}()
+			var lastMod time.Time
+			ticker := time.NewTicker(1 * time.Second)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-closed:
+					return
+				case <-ws.Request().Context().Done():
+					return
+				case <-ticker.C:
+				}
+				stat, err := os.Stat(utils.OutputJoin(id, job.ProgressFile))
+				if err != nil {
+					continue
+				}
+				if !stat.ModTime().After(lastMod) {
+					// the next task of a job starts shortly after the previous one finished
+					if finished(id) && time.Since(lastMod) > progressLinger {
+						return
+					}
+					continue
+				}
+				lastMod = stat.ModTime()
+				content, err := os.ReadFile(utils.OutputJoin(id, job.ProgressFile))
+				if err != nil {
+					continue
+				}
+				err = websocket.Message.Send(ws, string(content))
+				if err != nil {
+					return
+				}
+			}
+		}).ServeHTTP(c.Response(), c.Request())
+		return nil
+	})
+	e.GET("/glossary", func(c echo.Context) error {
+		shows, err := glossary.List()
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, shows)
+	})
+	e.GET("/glossary/:show", func(c echo.Context) error {
+		show := c.Param("show")
+		if !glossary.ValidShowId(show) {
+			return c.String(http.StatusBadRequest, "Invalid show id")
+		}
+		g, err := glossary.Load(show)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, g)
+	})
+	e.PUT("/glossary/:show", func(c echo.Context) error {
+		show := c.Param("show")
+		if !glossary.ValidShowId(show) {
+			return c.String(http.StatusBadRequest, "Invalid show id")
+		}
+		g := &glossary.Glossary{}
+		err := c.Bind(g)
+		if err != nil {
+			return c.String(http.StatusBadRequest, "Invalid glossary")
+		}
+		g.Show = show
+		err = glossary.Save(g)
+		if err != nil {
+			return err
+		}
+		return c.JSON(http.StatusOK, g)
+	})
+	e.DELETE("/glossary/:show", func(c echo.Context) error {
+		show := c.Param("show")
+		if !glossary.ValidShowId(show) {
+			return c.String(http.StatusBadRequest, "Invalid show id")
+		}
+		err := glossary.Delete(show)
+		if err != nil {
+			return err
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
 	//e.GET("/job/:id", func(c echo.Context) error {
 	//	id := c.Param("id")
 	//	job := populate(id)
@@ -317,35 +551,71 @@ func routes() {
 		if !ok {
 			return c.String(http.StatusNotFound, "Room not found")
 		}
+		limit := config.TheConfig.ChatPageSize
+		if l, err := strconv.Atoi(c.QueryParam("limit")); err == nil && l > 0 {
+			limit = l
+		}
+		var cursor int64
+		if cur := c.QueryParam("cursor"); cur != "" {
+			var err error
+			if cursor, err = strconv.ParseInt(cur, 10, 64); err != nil || cursor <= 0 {
+				return c.String(http.StatusBadRequest, "Invalid cursor")
+			}
+		}
 		room := roomI.(*Room)
 		room.mutex.RLock()
 		defer room.mutex.RUnlock()
-		return c.JSON(http.StatusOK, room)
+		chats, next := room.chatsBefore(cursor, limit)
+		// chats older than the page are fetched again with ?cursor=<cursor>, the id of the oldest chat of the page
+		return c.JSON(http.StatusOK, struct {
+			*Room
+			Chats  []*discord.Chat `json:"chats"`
+			Cursor int64           `json:"cursor,omitempty"`
+		}{room, chats, next})
 	})
 	e.GET("/sync/:room/:id", func(c echo.Context) error {
 		roomId := c.Param("room")
 		id := c.Param("id")
 		websocket.Handler(func(ws *websocket.Conn) {
-			currentPlayer := &Player{ws: ws,
+			currentPlayer := &Player{ws: ws, joined: time.Now().UnixNano(),
 				PlayerState: PlayerState{Id: id, LastSeen: time.Now().Unix()},
 			}
-			if _, ok := wss.Load(roomId); !ok {
-				wss.Store(roomId, &Room{Players: make(map[string]*Player), id: id,
-					VideoState: defaultVideoState(), Chats: make([]*discord.Chat, 0)})
+			var room *Room
+			for {
+				roomI, _ := wss.LoadOrStore(roomId, newRoom(roomId))
+				room = roomI.(*Room)
+				room.mutex.Lock()
+				// a room expired between loading and locking it is replaced by a new one
+				if !room.expired {
+					break
+				}
+				room.mutex.Unlock()
 			}
-			roomI, _ := wss.Load(roomId)
-			room := roomI.(*Room)
 			defer func() {
 				room.mutex.Lock()
 				defer room.mutex.Unlock()
 				Exit(room, currentPlayer)
 			}()
-			room.mutex.Lock()
+			if room.Kicked[id] {
+				currentPlayer.exited = true
+				room.mutex.Unlock()
+				currentPlayer.reject(KickSync, "you were kicked from this room")
+				return
+			}
 			if room.Players[id] != nil {
 				old := room.Players[id]
+				old.replaced = true
 				Exit(room, old)
 			}
 			room.Players[id] = currentPlayer
+			room.join(currentPlayer)
+			room.broadcastRole(id, nil)
+			currentPlayer.Send(SendPayload{Type: PingSync, Timestamp: time.Now().UnixMilli()})
+			if room.Current != "" {
+				room.sendMedia(currentPlayer, nil)
+				currentPlayer.Send(SendPayload{Type: QueueSync, Queue: room.Queue, JobId: room.Current,
+					Timestamp: time.Now().UnixMilli()})
+			}
 			room.mutex.Unlock()
 			log.Infof("[%v] connected", id)
 			for {
@@ -355,6 +625,7 @@ func routes() {
 					discord.Errorf("error receiving message: %v", err)
 					return
 				}
+				received := time.Now().UnixMilli()
 				payload := &PlayerPayload{}
 				err = json.Unmarshal([]byte(msg), payload)
 				if err != nil {
@@ -365,6 +636,9 @@ func routes() {
 					room.mutex.Lock()
 					defer room.mutex.Unlock()
 					currentPlayer.LastSeen = time.Now().Unix()
+					if room.handleModeration(currentPlayer, payload) || room.handleQueue(currentPlayer, payload) {
+						return
+					}
 					switch payload.Type {
 					case StateSync:
 						switch payload.State {
@@ -384,6 +658,10 @@ func routes() {
 						currentPlayer.Name = payload.Name
 						currentPlayer.DiscordUser = payload.DiscordUser
 					case BroadcastSync:
+						if currentPlayer.Muted {
+							currentPlayer.reject(payload.Type, "you are muted")
+							return
+						}
 						now := time.Now().UnixMilli()
 						for _, player := range room.Players {
 							player.Send(SendPayload{Type: BroadcastSync,
@@ -394,47 +672,127 @@ func routes() {
 						if strings.TrimSpace(payload.Chat) == "" {
 							return
 						}
-						room.Chats = append(room.Chats, &discord.Chat{Message: payload.Chat,
+						if currentPlayer.Muted {
+							currentPlayer.reject(payload.Type, "you are muted")
+							return
+						}
+						room.lastChatId++
+						room.Chats = append(room.Chats, &discord.Chat{Id: room.lastChatId, Message: payload.Chat,
 							Uid:       currentPlayer.Id,
 							Timestamp: time.Now().UnixMilli(), MediaSec: currentPlayer.Time})
+						room.trimChats()
+						room.dirty = true
 						for _, player := range room.Players {
 							room.syncChatsToPlayerUnsafe(player)
 						}
 						go func() {
 							discord.Webhook(utils.FormatSecondsToTime(currentPlayer.Time)+": "+payload.Chat, currentPlayer.Name, currentPlayer.Id)
 						}()
+					case PingSync:
+						currentPlayer.Send(SendPayload{Type: PongSync, Echo: payload.Timestamp,
+							Received: received, Timestamp: time.Now().UnixMilli()})
+					case PongSync:
+						currentPlayer.addLatencySample(payload.Echo, payload.Received, payload.Timestamp, received)
 					case TimeSync:
+						if payload.Time == nil || !room.playing(payload.JobId) {
+							return
+						}
+						// the reported time is where the player was when it sent the message
 						currentPlayer.Time = *payload.Time
-						if math.Abs(room.Time-currentPlayer.Time) > 5 &&
+						if !currentPlayer.Paused {
+							currentPlayer.Time += room.Clock.Rate *
+								float64(received-currentPlayer.serverTime(payload.Timestamp, received)) / 1000
+						}
+						room.Positions[currentPlayer.Id] = currentPlayer.Time
+						room.dirty = true
+						room.recordWatch(currentPlayer, payload.JobId)
+						roomTime := room.Clock.At(received)
+						if math.Abs(roomTime-currentPlayer.Time) > room.syncThreshold() &&
 							room.LastSeek.Add(1*time.Second).Before(time.Now()) {
-							log.Debugf("[%v] player time: %v, room time: %v", currentPlayer.Name, currentPlayer.Time, room.Time)
+							log.Debugf("[%v] player time: %v, room time: %v", currentPlayer.Name, currentPlayer.Time, roomTime)
+							if !room.canControl(currentPlayer) {
+								// pull the player back instead of letting it move the room
+								currentPlayer.reject(payload.Type, "not allowed to seek in this room")
+								currentPlayer.Sync(room, true, false, nil)
+								return
+							}
+							room.Clock.Seek(currentPlayer.Time, received)
 							for _, p := range room.Players {
 								if currentPlayer.Id == p.Id {
 									continue
 								}
-								p.Sync(&currentPlayer.Time, nil, currentPlayer)
+								p.Sync(room, true, false, currentPlayer)
 							}
 							room.LastSeek = time.Now()
 						}
-						room.Time = currentPlayer.Time
+						room.Time = room.Clock.At(received)
 					case PauseSync:
+						if payload.Paused == nil || !room.playing(payload.JobId) {
+							return
+						}
+						if !room.canControl(currentPlayer) {
+							currentPlayer.reject(payload.Type, "not allowed to pause in this room")
+							currentPlayer.Sync(room, false, true, nil)
+							return
+						}
 						currentPlayer.Paused = *payload.Paused
 						log.Debugf("[%v] player paused: %v, room paused: %v", currentPlayer.Name, currentPlayer.Paused, room.Paused)
-						room.Paused = currentPlayer.Paused
+						room.Clock.SetPaused(currentPlayer.Paused, received)
+						room.dirty = true
+						room.VideoState = VideoState{Time: room.Clock.At(received), Paused: room.Clock.Paused}
+						for _, p := range room.Players {
+							if currentPlayer.Id == p.Id {
+								continue
+							}
+							p.Sync(room, false, true, currentPlayer)
+						}
+					case RateSync:
+						if payload.Rate == nil || *payload.Rate <= 0 {
+							return
+						}
+						if !room.canControl(currentPlayer) {
+							currentPlayer.reject(payload.Type, "not allowed to change the speed in this room")
+							return
+						}
+						room.Clock.SetRate(*payload.Rate, received)
+						room.dirty = true
+						clock := room.Clock
 						for _, p := range room.Players {
 							if currentPlayer.Id == p.Id {
 								continue
 							}
-							p.Sync(nil, &room.Paused, currentPlayer)
+							p.Send(SendPayload{Type: RateSync, Clock: &clock, FiredBy: currentPlayer, Timestamp: received})
+						}
+					case ThresholdSync:
+						if payload.Threshold == nil || *payload.Threshold <= 0 {
+							return
+						}
+						if !room.canControl(currentPlayer) {
+							currentPlayer.reject(payload.Type, "not allowed to change the threshold in this room")
+							return
+						}
+						room.Threshold = *payload.Threshold
+						room.dirty = true
+						for _, p := range room.Players {
+							p.Send(SendPayload{Type: ThresholdSync, Threshold: &room.Threshold,
+								FiredBy: currentPlayer, Timestamp: received})
 						}
 					case NewPlayer:
-						paused := false
-						currentPlayer.Sync(&room.VideoState.Time, &paused, nil)
+						if !room.canControl(currentPlayer) {
+							// joining doesn't start playback for everyone when the player can't control it
+							currentPlayer.Sync(room, true, true, nil)
+							room.syncChatsToPlayerUnsafe(currentPlayer)
+							return
+						}
+						room.Clock.SetPaused(false, received)
+						room.VideoState = VideoState{Time: room.Clock.At(received), Paused: false}
+						room.dirty = true
+						currentPlayer.Sync(room, true, true, nil)
 						for _, p := range room.Players {
 							if currentPlayer.Id == p.Id {
 								continue
 							}
-							p.Sync(nil, &paused, currentPlayer)
+							p.Sync(room, false, true, currentPlayer)
 						}
 						room.syncChatsToPlayerUnsafe(currentPlayer)
 					}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"Sparkle/config"
+	"time"
+)
+
+const (
+	PingSync      = "ping"
+	PongSync      = "pong"
+	RateSync      = "rate"
+	ThresholdSync = "threshold"
+)
+
+// pingInterval is how often every player is pinged to keep its round-trip time and clock offset fresh
+const pingInterval = 5
+
+// latencyWeight is the weight of a new sample in the moving averages of round-trip time and clock offset
+const latencyWeight = 0.2
+
+// PlaybackClock is the authoritative position of a room: Position seconds at the server time Anchor, in unix
+// milliseconds, moving at Rate while not paused
+type PlaybackClock struct {
+	Position float64 `json:"position"`
+	Rate     float64 `json:"rate"`
+	Anchor   int64   `json:"anchor"`
+	Paused   bool    `json:"paused"`
+}
+
+func newPlaybackClock() PlaybackClock {
+	return PlaybackClock{Rate: 1, Anchor: time.Now().UnixMilli(), Paused: true}
+}
+
+// At returns the position of the clock at the server time ms
+func (c PlaybackClock) At(ms int64) float64 {
+	if c.Paused {
+		return c.Position
+	}
+	return c.Position + c.Rate*float64(ms-c.Anchor)/1000
+}
+
+// Seek moves the clock to position at the server time ms
+func (c *PlaybackClock) Seek(position float64, ms int64) {
+	c.Position, c.Anchor = position, ms
+}
+
+// SetPaused stops or starts the clock at the server time ms
+func (c *PlaybackClock) SetPaused(paused bool, ms int64) {
+	c.Position, c.Anchor, c.Paused = c.At(ms), ms, paused
+}
+
+// SetRate changes the playback speed from the server time ms on
+func (c *PlaybackClock) SetRate(rate float64, ms int64) {
+	c.Position, c.Anchor, c.Rate = c.At(ms), ms, rate
+}
+
+// addLatencySample folds one ping exchange into the player's estimates. t0 and t3 are the server times the ping
+// was sent and the pong received, t1 and t2 the player's times it received the ping and sent the pong.
+// Players that don't report their own times only get a round-trip time.
+func (player *Player) addLatencySample(t0, t1, t2, t3 int64) {
+	rtt := float64(t3 - t0)
+	offset := player.ClockOffset
+	if t1 != 0 && t2 != 0 {
+		rtt = float64((t3 - t0) - (t2 - t1))
+		offset = float64((t1-t0)+(t2-t3)) / 2
+	}
+	if rtt < 0 {
+		return
+	}
+	if player.latencySamples == 0 {
+		player.Rtt, player.ClockOffset = rtt, offset
+	} else {
+		player.Rtt += latencyWeight * (rtt - player.Rtt)
+		player.ClockOffset += latencyWeight * (offset - player.ClockOffset)
+	}
+	player.latencySamples++
+}
+
+// serverTime converts a timestamp of the player's clock to the server's, a missing timestamp is taken
+// as half a round trip ago
+func (player *Player) serverTime(clientMs, now int64) int64 {
+	if clientMs == 0 || player.latencySamples == 0 {
+		return now - int64(player.Rtt/2)
+	}
+	return min(clientMs-int64(player.ClockOffset), now)
+}
+
+// targetFor is where player should be once a message sent at the server time now reaches it
+func (room *Room) targetFor(player *Player, now int64) float64 {
+	return room.Clock.At(now + int64(player.Rtt/2))
+}
+
+// syncThreshold is how far, in seconds, a player may drift from the room clock before it counts as a seek
+func (room *Room) syncThreshold() float64 {
+	if room.Threshold > 0 {
+		return room.Threshold
+	}
+	return config.TheConfig.SyncThreshold
+}
+
+// pingPlayers sends every player the server time, the pong it answers with updates its latency estimates
+func pingPlayers() {
+	wss.Range(func(key, value interface{}) bool {
+		room := value.(*Room)
+		room.mutex.Lock()
+		defer room.mutex.Unlock()
+		for _, player := range room.Players {
+			player.Send(SendPayload{Type: PingSync, Timestamp: time.Now().UnixMilli()})
+		}
+		return true
+	})
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestPlaybackClock(t *testing.T) {
+	c := PlaybackClock{Rate: 1, Anchor: 1000, Paused: true}
+	if got := c.At(5000); got != 0 {
+		t.Errorf("A paused clock moved to %v", got)
+	}
+	c.SetPaused(false, 1000)
+	if got := c.At(3000); got != 2 {
+		t.Errorf("Expected 2s after 2s of playback, got %v", got)
+	}
+	c.SetRate(2, 3000)
+	if got := c.At(4000); got != 4 {
+		t.Errorf("Expected 4s after a second at double speed, got %v", got)
+	}
+	c.Seek(10, 4000)
+	if got := c.At(4500); got != 11 {
+		t.Errorf("Expected 11s half a second after seeking to 10s, got %v", got)
+	}
+	c.SetPaused(true, 5000)
+	if got := c.At(9000); got != 12 || !c.Paused {
+		t.Errorf("Expected the clock to stop at 12s, got %v", got)
+	}
+}
+
+func TestAddLatencySample(t *testing.T) {
+	player := &Player{}
+	// 100ms round trip with 40ms spent on the player, whose clock is 500ms ahead
+	player.addLatencySample(1000, 1530, 1570, 1100)
+	if player.Rtt != 60 || player.ClockOffset != 500 {
+		t.Fatalf("Unexpected first estimates: rtt %v, offset %v", player.Rtt, player.ClockOffset)
+	}
+	player.addLatencySample(2000, 2580, 2580, 2160)
+	if math.Abs(player.Rtt-80) > 1e-9 || math.Abs(player.ClockOffset-500) > 1e-9 {
+		t.Errorf("Unexpected averaged estimates: rtt %v, offset %v", player.Rtt, player.ClockOffset)
+	}
+	// a pong received before its ping was sent is left out
+	player.addLatencySample(3000, 0, 0, 2900)
+	if player.latencySamples != 2 {
+		t.Errorf("A negative round trip was counted")
+	}
+	// players that don't report their times keep their offset
+	player.addLatencySample(4000, 0, 0, 4080)
+	if math.Abs(player.Rtt-80) > 1e-9 || math.Abs(player.ClockOffset-500) > 1e-9 {
+		t.Errorf("Unexpected estimates without player times: rtt %v, offset %v", player.Rtt, player.ClockOffset)
+	}
+}
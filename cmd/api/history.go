@@ -0,0 +1,255 @@
+package main
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/job"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const historyDir = "history"
+
+// completedRatio is how much of a job has to be watched for it to count as completed
+const completedRatio = 0.9
+
+// continueWatchingLimit is the length of the continue watching list of /all
+const continueWatchingLimit = 20
+
+// WatchEntry is where a user is in one job
+type WatchEntry struct {
+	JobId       string  `json:"jobId"`
+	Position    float64 `json:"position"`
+	Duration    float64 `json:"duration,omitempty"`
+	Completed   bool    `json:"completed"`
+	LastWatched int64   `json:"lastWatched"`
+}
+
+// WatchHistory is kept per user, by Discord user id when the player is logged in and by player id otherwise,
+// in DataDir/history/<user>.json
+type WatchHistory struct {
+	mutex   sync.Mutex
+	User    string                 `json:"user"`
+	Entries map[string]*WatchEntry `json:"entries"`
+	dirty   bool
+	evicted bool // dropped from histories by saveHistories, recordWatch loads the user again
+}
+
+var histories sync.Map // map[string]*WatchHistory
+
+func historyPath(user string) string {
+	return filepath.Join(config.TheConfig.DataDir, historyDir, user+".json")
+}
+
+// loadHistory reads the history of a user from disk, a user without a file gets an empty one
+func loadHistory(user string) *WatchHistory {
+	h := &WatchHistory{User: user, Entries: make(map[string]*WatchEntry)}
+	content, err := os.ReadFile(historyPath(user))
+	if err == nil {
+		if err := json.Unmarshal(content, h); err != nil {
+			discord.Errorf("error reading history of %s: %v", user, err)
+		}
+		if h.Entries == nil {
+			h.Entries = make(map[string]*WatchEntry)
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		discord.Errorf("error reading history of %s: %v", user, err)
+	}
+	return h
+}
+
+// historyOf returns the history of a user to record in, loading it the first time
+func historyOf(user string) *WatchHistory {
+	if h, ok := histories.Load(user); ok {
+		return h.(*WatchHistory)
+	}
+	actual, _ := histories.LoadOrStore(user, loadHistory(user))
+	return actual.(*WatchHistory)
+}
+
+// readHistory returns the history of a user to read from, only players watching are kept in memory
+func readHistory(user string) *WatchHistory {
+	if h, ok := histories.Load(user); ok {
+		return h.(*WatchHistory)
+	}
+	return loadHistory(user)
+}
+
+// userKey is who the history of a player belongs to
+func (player *Player) userKey() string {
+	if player.DiscordUser != nil && player.DiscordUser.ID != "" {
+		return player.DiscordUser.ID
+	}
+	return player.Id
+}
+
+// jobId is the job the room is playing, rooms without a queue are named after their job
+func (room *Room) jobId() string {
+	if room.Current != "" {
+		return room.Current
+	}
+	if validJobId(room.id) && findJob(room.id) != nil {
+		return room.id
+	}
+	return ""
+}
+
+// recordWatch saves where player is in the job the room is playing, reported is the job the player said it
+// reports about, a report about another one is left out
+func (room *Room) recordWatch(player *Player, reported string) {
+	jobId := room.jobId()
+	user := player.userKey()
+	if jobId == "" || reported != "" && reported != jobId || room.Current != "" && reported == "" ||
+		!validJobId(user) {
+		return
+	}
+	duration := room.duration
+	if duration <= 0 {
+		if j := findJob(jobId); j != nil {
+			duration = j.Duration
+		}
+	}
+	h := historyOf(user)
+	h.mutex.Lock()
+	for h.evicted {
+		h.mutex.Unlock()
+		h = historyOf(user)
+		h.mutex.Lock()
+	}
+	defer h.mutex.Unlock()
+	entry, ok := h.Entries[jobId]
+	if !ok {
+		entry = &WatchEntry{JobId: jobId}
+		h.Entries[jobId] = entry
+	}
+	entry.Position = player.Time
+	entry.Duration = duration
+	entry.LastWatched = time.Now().Unix()
+	if duration > 0 && player.Time >= duration*completedRatio {
+		entry.Completed = true
+	}
+	h.dirty = true
+}
+
+// sorted returns the entries of the history, the last watched first
+func (h *WatchHistory) sorted() []WatchEntry {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	entries := make([]WatchEntry, 0, len(h.Entries))
+	for _, entry := range h.Entries {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].LastWatched > entries[j].LastWatched
+	})
+	return entries
+}
+
+// watchingUsers returns the users of every player in a room
+func watchingUsers() map[string]bool {
+	users := make(map[string]bool)
+	wss.Range(func(key, value interface{}) bool {
+		room := value.(*Room)
+		room.mutex.RLock()
+		defer room.mutex.RUnlock()
+		for _, player := range room.Players {
+			users[player.userKey()] = true
+		}
+		return true
+	})
+	return users
+}
+
+// saveHistories writes the histories that changed since they were last saved, those of users no longer
+// in any room are dropped from memory once written
+func saveHistories() {
+	err := os.MkdirAll(filepath.Join(config.TheConfig.DataDir, historyDir), 0755)
+	if err != nil {
+		discord.Errorf("error creating history directory: %v", err)
+		return
+	}
+	watching := watchingUsers()
+	histories.Range(func(key, value interface{}) bool {
+		h := value.(*WatchHistory)
+		h.mutex.Lock()
+		if !h.dirty {
+			if !watching[h.User] {
+				h.evicted = true
+				histories.Delete(key)
+			}
+			h.mutex.Unlock()
+			return true
+		}
+		content, err := json.Marshal(h)
+		h.dirty = false
+		h.mutex.Unlock()
+		if err == nil {
+			err = writeFileAtomic(historyPath(h.User), content)
+		}
+		if err != nil {
+			discord.Errorf("error saving history of %s: %v", h.User, err)
+			h.mutex.Lock()
+			h.dirty = true
+			h.mutex.Unlock()
+		}
+		return true
+	})
+}
+
+// UserJob is a job with where the user is in it
+type UserJob struct {
+	*job.JobStripped
+	Position    float64 `json:"position"`
+	Progress    float64 `json:"progress"` // watched part, from 0 to 1
+	Completed   bool    `json:"completed"`
+	LastWatched int64   `json:"lastWatched,omitempty"`
+}
+
+// UserJobs is /all for one user
+type UserJobs struct {
+	Jobs             []UserJob `json:"jobs"`
+	ContinueWatching []UserJob `json:"continueWatching"`
+}
+
+func userJobs(user string) (UserJobs, error) {
+	jobs, err := job.JobsCache.Get(false)
+	if err != nil {
+		return UserJobs{}, err
+	}
+	entries := make(map[string]WatchEntry)
+	for _, entry := range readHistory(user).sorted() {
+		entries[entry.JobId] = entry
+	}
+	result := UserJobs{Jobs: make([]UserJob, 0, len(jobs)), ContinueWatching: make([]UserJob, 0)}
+	for _, j := range jobs {
+		uj := UserJob{JobStripped: j}
+		if entry, ok := entries[j.Id]; ok {
+			uj.Position, uj.Completed, uj.LastWatched = entry.Position, entry.Completed, entry.LastWatched
+			duration := j.Duration
+			if duration <= 0 {
+				duration = entry.Duration
+			}
+			if duration > 0 {
+				uj.Progress = min(entry.Position/duration, 1)
+			}
+			if uj.Completed {
+				uj.Progress = 1
+			} else if entry.Position > 0 {
+				result.ContinueWatching = append(result.ContinueWatching, uj)
+			}
+		}
+		result.Jobs = append(result.Jobs, uj)
+	}
+	sort.Slice(result.ContinueWatching, func(i, k int) bool {
+		return result.ContinueWatching[i].LastWatched > result.ContinueWatching[k].LastWatched
+	})
+	if len(result.ContinueWatching) > continueWatchingLimit {
+		result.ContinueWatching = result.ContinueWatching[:continueWatchingLimit]
+	}
+	return result, nil
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"Sparkle/job"
+	"slices"
+	"time"
+)
+
+const (
+	QueueAdd    = "queue add"
+	QueueRemove = "queue remove"
+	QueueMove   = "queue move"
+	QueueNext   = "next"
+	QueueSync   = "queue"
+	MediaSync   = "media"
+)
+
+// findJob looks a job up in job.JobsCache
+func findJob(id string) *job.JobStripped {
+	jobs, err := job.JobsCache.Get(false)
+	if err != nil {
+		return nil
+	}
+	for _, j := range jobs {
+		if j.Id == id {
+			return j
+		}
+	}
+	return nil
+}
+
+func (room *Room) broadcastQueue(firedBy *Player) {
+	for _, p := range room.Players {
+		p.Send(SendPayload{Type: QueueSync, Queue: room.Queue, JobId: room.Current, FiredBy: firedBy,
+			Timestamp: time.Now().UnixMilli()})
+	}
+}
+
+func (room *Room) sendMedia(player *Player, firedBy *Player) {
+	now := time.Now().UnixMilli()
+	clock := room.Clock
+	target := room.targetFor(player, now)
+	player.Send(SendPayload{Type: MediaSync, JobId: room.Current, Time: &target, Paused: &clock.Paused,
+		Clock: &clock, FiredBy: firedBy, Timestamp: now})
+}
+
+// playing tells whether a report about jobId is about what the room plays, players still on the previous
+// job after a media switch would otherwise move the room to their old position
+func (room *Room) playing(jobId string) bool {
+	return room.Current == "" || jobId == room.Current
+}
+
+// play switches the room to a job from its start, playback keeps going if the room was playing
+func (room *Room) play(id string, firedBy *Player) {
+	now := time.Now().UnixMilli()
+	room.Current = id
+	room.duration = 0
+	if j := findJob(id); j != nil {
+		room.duration = j.Duration
+	}
+	room.Clock.Seek(0, now)
+	room.Time, room.Paused = 0, room.Clock.Paused
+	room.LastSeek = time.Now()
+	room.dirty = true
+	for _, p := range room.Players {
+		p.Time = 0
+		room.sendMedia(p, firedBy)
+	}
+}
+
+// advance plays the next job of the queue, the room stops at the end of the current one when the queue is empty
+func (room *Room) advance(firedBy *Player) {
+	if len(room.Queue) == 0 {
+		room.Clock.SetPaused(true, time.Now().UnixMilli())
+		room.Paused = true
+		room.dirty = true
+		for _, p := range room.Players {
+			p.Sync(room, false, true, firedBy)
+		}
+		return
+	}
+	next := room.Queue[0]
+	room.Queue = slices.Delete(room.Queue, 0, 1)
+	room.play(next, firedBy)
+	room.broadcastQueue(firedBy)
+}
+
+// handleQueue handles queue add, remove, move and next messages, it returns false for other types
+func (room *Room) handleQueue(player *Player, payload *PlayerPayload) bool {
+	switch payload.Type {
+	case QueueAdd, QueueRemove, QueueMove, QueueNext:
+	default:
+		return false
+	}
+	if !room.canControl(player) {
+		player.reject(payload.Type, "not allowed to change the queue in this room")
+		return true
+	}
+	switch payload.Type {
+	case QueueAdd:
+		if !validJobId(payload.JobId) || findJob(payload.JobId) == nil {
+			player.reject(payload.Type, "unknown job")
+			return true
+		}
+		if room.Current == "" {
+			room.play(payload.JobId, player)
+		} else {
+			room.Queue = append(room.Queue, payload.JobId)
+		}
+	case QueueRemove:
+		if payload.Index == nil || *payload.Index < 0 || *payload.Index >= len(room.Queue) {
+			player.reject(payload.Type, "index out of range")
+			return true
+		}
+		room.Queue = slices.Delete(room.Queue, *payload.Index, *payload.Index+1)
+	case QueueMove:
+		if payload.Index == nil || payload.To == nil || *payload.Index < 0 || *payload.Index >= len(room.Queue) ||
+			*payload.To < 0 || *payload.To >= len(room.Queue) {
+			player.reject(payload.Type, "index out of range")
+			return true
+		}
+		id := room.Queue[*payload.Index]
+		room.Queue = slices.Insert(slices.Delete(room.Queue, *payload.Index, *payload.Index+1), *payload.To, id)
+	case QueueNext:
+		if len(room.Queue) == 0 {
+			player.reject(payload.Type, "the queue is empty")
+			return true
+		}
+		room.advance(player)
+		return true
+	}
+	room.dirty = true
+	room.broadcastQueue(player)
+	return true
+}
+
+// advanceRooms moves every room whose clock reached the end of its job on to the next one
+func advanceRooms() {
+	wss.Range(func(key, value interface{}) bool {
+		room := value.(*Room)
+		room.mutex.Lock()
+		defer room.mutex.Unlock()
+		if room.Current == "" || room.Clock.Paused || len(room.Players) == 0 {
+			return true
+		}
+		if room.duration <= 0 {
+			if j := findJob(room.Current); j != nil {
+				room.duration = j.Duration
+			}
+		}
+		if room.duration > 0 && room.Clock.At(time.Now().UnixMilli()) >= room.duration {
+			room.advance(nil)
+		}
+		return true
+	})
+}
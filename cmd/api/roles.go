@@ -0,0 +1,207 @@
+package main
+
+import (
+	"slices"
+	"time"
+)
+
+const (
+	RoleSync     = "role"
+	HostTransfer = "host"
+	KickSync     = "kick"
+	MuteSync     = "mute"
+	PolicySync   = "policy"
+	ErrorSync    = "error"
+)
+
+const (
+	RoleHost      = "host"
+	RoleModerator = "moderator"
+	RoleViewer    = "viewer"
+)
+
+// Control policies, who may pause, seek and change the speed of a room
+const (
+	PolicyEveryone   = "everyone"
+	PolicyModerators = "moderators"
+	PolicyHost       = "host"
+)
+
+func rank(role string) int {
+	switch role {
+	case RoleHost:
+		return 2
+	case RoleModerator:
+		return 1
+	}
+	return 0
+}
+
+func (room *Room) roleOf(id string) string {
+	if role, ok := room.Roles[id]; ok {
+		return role
+	}
+	return RoleViewer
+}
+
+func (room *Room) hasHost() bool {
+	for id, role := range room.Roles {
+		if _, connected := room.Players[id]; connected && role == RoleHost {
+			return true
+		}
+	}
+	return false
+}
+
+// setRole records the role of a player on the room and on the player if it is connected
+func (room *Room) setRole(id, role string) {
+	room.Roles[id] = role
+	if player, ok := room.Players[id]; ok {
+		player.Role = role
+	}
+}
+
+// join gives a connecting player its role, the first one in the room becomes host
+func (room *Room) join(player *Player) {
+	if room.Roles == nil {
+		room.Roles = make(map[string]string)
+	}
+	role := room.roleOf(player.Id)
+	if !room.hasHost() {
+		role = RoleHost
+		for id, r := range room.Roles {
+			if r == RoleHost && id != player.Id {
+				room.Roles[id] = RoleModerator
+			}
+		}
+	}
+	room.setRole(player.Id, role)
+	player.Muted = room.Muted[player.Id]
+}
+
+// leave hands the host role over when the host disconnects, moderators first and then whoever joined first
+func (room *Room) leave(player *Player) {
+	if room.roleOf(player.Id) != RoleHost || len(room.Players) == 0 {
+		return
+	}
+	var next *Player
+	for _, p := range room.Players {
+		if next == nil || rank(room.roleOf(p.Id)) > rank(room.roleOf(next.Id)) ||
+			rank(room.roleOf(p.Id)) == rank(room.roleOf(next.Id)) && p.joined < next.joined {
+			next = p
+		}
+	}
+	room.setRole(player.Id, RoleModerator)
+	room.setRole(next.Id, RoleHost)
+	room.broadcastRole(next.Id, nil)
+}
+
+// canControl tells whether the room's policy lets player pause, seek and change the speed
+func (room *Room) canControl(player *Player) bool {
+	switch room.Policy {
+	case PolicyHost:
+		return room.roleOf(player.Id) == RoleHost
+	case PolicyModerators:
+		return rank(room.roleOf(player.Id)) >= rank(RoleModerator)
+	}
+	return true
+}
+
+// outranks tells whether actor may kick or mute target
+func (room *Room) outranks(actor *Player, target string) bool {
+	return rank(room.roleOf(actor.Id)) > rank(room.roleOf(target)) &&
+		rank(room.roleOf(actor.Id)) >= rank(RoleModerator)
+}
+
+// reject answers a message the player wasn't allowed to send
+func (player *Player) reject(messageType, reason string) {
+	player.Send(SendPayload{Type: ErrorSync, Error: reason, Rejected: messageType, Timestamp: time.Now().UnixMilli()})
+}
+
+func (room *Room) broadcastRole(id string, firedBy *Player) {
+	role := room.roleOf(id)
+	for _, p := range room.Players {
+		p.Send(SendPayload{Type: RoleSync, Target: id, Role: role, FiredBy: firedBy, Timestamp: time.Now().UnixMilli()})
+	}
+}
+
+// handleModeration handles role, host transfer, kick, mute and policy messages, it returns false for other types
+func (room *Room) handleModeration(player *Player, payload *PlayerPayload) bool {
+	switch payload.Type {
+	case RoleSync:
+		if room.roleOf(player.Id) != RoleHost {
+			player.reject(payload.Type, "only the host can change roles")
+		} else if payload.Role != RoleModerator && payload.Role != RoleViewer {
+			player.reject(payload.Type, "unknown role, use host transfer to hand over the room")
+		} else if payload.Target == player.Id || room.Players[payload.Target] == nil {
+			player.reject(payload.Type, "unknown player")
+		} else {
+			room.setRole(payload.Target, payload.Role)
+			room.broadcastRole(payload.Target, player)
+		}
+	case HostTransfer:
+		if room.roleOf(player.Id) != RoleHost {
+			player.reject(payload.Type, "only the host can hand over the room")
+		} else if payload.Target == player.Id || room.Players[payload.Target] == nil {
+			player.reject(payload.Type, "unknown player")
+		} else {
+			room.setRole(player.Id, RoleModerator)
+			room.setRole(payload.Target, RoleHost)
+			room.broadcastRole(player.Id, player)
+			room.broadcastRole(payload.Target, player)
+		}
+	case KickSync:
+		target, ok := room.Players[payload.Target]
+		if !ok {
+			player.reject(payload.Type, "unknown player")
+		} else if !room.outranks(player, payload.Target) {
+			player.reject(payload.Type, "not allowed to kick this player")
+		} else {
+			for _, p := range room.Players {
+				p.Send(SendPayload{Type: KickSync, Target: target.Id, FiredBy: player, Timestamp: time.Now().UnixMilli()})
+			}
+			if room.Kicked == nil {
+				room.Kicked = make(map[string]bool)
+			}
+			room.Kicked[target.Id] = true
+			Exit(room, target)
+		}
+	case MuteSync:
+		if payload.Muted == nil || room.Players[payload.Target] == nil && room.Roles[payload.Target] == "" {
+			player.reject(payload.Type, "unknown player")
+		} else if !room.outranks(player, payload.Target) {
+			player.reject(payload.Type, "not allowed to mute this player")
+		} else {
+			if room.Muted == nil {
+				room.Muted = make(map[string]bool)
+			}
+			if *payload.Muted {
+				room.Muted[payload.Target] = true
+			} else {
+				delete(room.Muted, payload.Target)
+			}
+			if target, ok := room.Players[payload.Target]; ok {
+				target.Muted = *payload.Muted
+			}
+			for _, p := range room.Players {
+				p.Send(SendPayload{Type: MuteSync, Target: payload.Target, Muted: payload.Muted,
+					FiredBy: player, Timestamp: time.Now().UnixMilli()})
+			}
+		}
+	case PolicySync:
+		if room.roleOf(player.Id) != RoleHost {
+			player.reject(payload.Type, "only the host can change the control policy")
+		} else if !slices.Contains([]string{PolicyEveryone, PolicyModerators, PolicyHost}, payload.Policy) {
+			player.reject(payload.Type, "unknown policy")
+		} else {
+			room.Policy = payload.Policy
+			room.dirty = true
+			for _, p := range room.Players {
+				p.Send(SendPayload{Type: PolicySync, Policy: room.Policy, FiredBy: player, Timestamp: time.Now().UnixMilli()})
+			}
+		}
+	default:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,261 @@
+package main
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const roomsDir = "rooms"
+
+// saveInterval is how often, in seconds, rooms that changed are written to the store
+const saveInterval = 10
+
+// RoomStore keeps rooms across restarts of the API
+type RoomStore interface {
+	Load() (map[string]*StoredRoom, error)
+	Save(id string, room *StoredRoom) error
+	Delete(id string) error
+}
+
+// StoredRoom is the part of a room that outlives its connections
+type StoredRoom struct {
+	Chats     []*discord.Chat    `json:"chats"`
+	LastSeek  time.Time          `json:"lastSeek"`
+	Clock     PlaybackClock      `json:"clock"`
+	Threshold float64            `json:"threshold,omitempty"`
+	Policy    string             `json:"policy,omitempty"`
+	Positions map[string]float64 `json:"positions,omitempty"`
+	Current   string             `json:"current,omitempty"`
+	Queue     []string           `json:"queue,omitempty"`
+	LastChat  int64              `json:"lastChat,omitempty"`
+	SavedAt   int64              `json:"savedAt"`
+}
+
+// fileRoomStore writes every room to DataDir/rooms/<room>.json
+type fileRoomStore struct{}
+
+var store RoomStore = &fileRoomStore{}
+
+func (s *fileRoomStore) path(id string) string {
+	return filepath.Join(config.TheConfig.DataDir, roomsDir, id+".json")
+}
+
+func (s *fileRoomStore) Load() (map[string]*StoredRoom, error) {
+	dir := filepath.Join(config.TheConfig.DataDir, roomsDir)
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return map[string]*StoredRoom{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	rooms := make(map[string]*StoredRoom, len(entries))
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok {
+			continue
+		}
+		content, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return nil, err
+		}
+		stored := &StoredRoom{}
+		if err := json.Unmarshal(content, stored); err != nil {
+			discord.Errorf("error reading room %s: %v", id, err)
+			continue
+		}
+		rooms[id] = stored
+	}
+	return rooms, nil
+}
+
+func (s *fileRoomStore) Save(id string, room *StoredRoom) error {
+	p := s.path(id)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return err
+	}
+	content, err := json.Marshal(room)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(p, content)
+}
+
+func (s *fileRoomStore) Delete(id string) error {
+	err := os.Remove(s.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// writeFileAtomic writes through a temporary file so a crash never leaves a truncated file behind
+func writeFileAtomic(path string, content []byte) error {
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func newRoom(id string) *Room {
+	return &Room{Players: make(map[string]*Player), id: id,
+		VideoState: defaultVideoState(), Clock: newPlaybackClock(), Chats: make([]*discord.Chat, 0),
+		Roles: make(map[string]string), Policy: PolicyEveryone, Positions: make(map[string]float64),
+		Queue: make([]string, 0), lastActive: time.Now().UnixMilli()}
+}
+
+// trimChats drops the chats over config.ChatHistoryLimit and those older than config.ChatHistoryMaxAge
+func (room *Room) trimChats() {
+	if limit := config.TheConfig.ChatHistoryLimit; limit > 0 && len(room.Chats) > limit {
+		room.Chats = room.Chats[len(room.Chats)-limit:]
+	}
+	if maxAge := config.TheConfig.ChatHistoryMaxAge; maxAge > 0 {
+		oldest := time.Now().Add(-maxAge).UnixMilli()
+		i := sort.Search(len(room.Chats), func(i int) bool { return room.Chats[i].Timestamp >= oldest })
+		room.Chats = room.Chats[i:]
+	}
+}
+
+// numberChats gives ids to chats stored before chats had them, keeping the ids increasing
+func (room *Room) numberChats() {
+	for _, chat := range room.Chats {
+		if chat.Id <= room.lastChatId {
+			chat.Id = room.lastChatId + 1
+		}
+		room.lastChatId = chat.Id
+	}
+}
+
+// chatsBefore returns up to limit chats sent before the chat with the id cursor, the newest when cursor is zero,
+// and the cursor of the next older page, zero when there is none
+func (room *Room) chatsBefore(cursor int64, limit int) ([]*discord.Chat, int64) {
+	end := len(room.Chats)
+	if cursor > 0 {
+		end = sort.Search(len(room.Chats), func(i int) bool { return room.Chats[i].Id >= cursor })
+	}
+	start := max(end-limit, 0)
+	page := room.Chats[start:end]
+	if start == 0 || len(page) == 0 {
+		return page, 0
+	}
+	return page, page[0].Id
+}
+
+// idle tells whether the room has been empty and unchanged for longer than config.RoomTTL
+func (room *Room) idle(now int64) bool {
+	ttl := config.TheConfig.RoomTTL
+	return ttl > 0 && len(room.Players) == 0 && now-room.lastActive > ttl.Milliseconds()
+}
+
+func (room *Room) stored() *StoredRoom {
+	return &StoredRoom{Chats: slices.Clone(room.Chats), LastSeek: room.LastSeek, Clock: room.Clock,
+		Threshold: room.Threshold, Policy: room.Policy, Positions: maps.Clone(room.Positions),
+		Current: room.Current, Queue: slices.Clone(room.Queue), LastChat: room.lastChatId,
+		SavedAt: time.Now().UnixMilli()}
+}
+
+// loadRooms fills wss from the store, the clock of a room that was playing stops where it was when saved
+func loadRooms() {
+	rooms, err := store.Load()
+	if err != nil {
+		discord.Errorf("error loading rooms: %v", err)
+		return
+	}
+	loaded := 0
+	for id, stored := range rooms {
+		room := newRoom(id)
+		room.lastActive = stored.SavedAt
+		if room.idle(time.Now().UnixMilli()) {
+			if err := store.Delete(id); err != nil {
+				discord.Errorf("error deleting room %s: %v", id, err)
+			}
+			continue
+		}
+		room.Chats = stored.Chats
+		if room.Chats == nil {
+			room.Chats = make([]*discord.Chat, 0)
+		}
+		room.LastSeek = stored.LastSeek
+		room.Clock = stored.Clock
+		room.Clock.SetPaused(true, stored.SavedAt)
+		room.Clock.Anchor = time.Now().UnixMilli()
+		room.Time, room.Paused = room.Clock.Position, true
+		room.Threshold = stored.Threshold
+		if stored.Policy != "" {
+			room.Policy = stored.Policy
+		}
+		if stored.Positions != nil {
+			room.Positions = stored.Positions
+		}
+		room.Current = stored.Current
+		if stored.Queue != nil {
+			room.Queue = stored.Queue
+		}
+		room.lastChatId = stored.LastChat
+		room.numberChats()
+		room.trimChats()
+		wss.Store(id, room)
+		loaded++
+	}
+	discord.Infof("Loaded %d rooms", loaded)
+}
+
+// saveRooms writes the rooms that changed since they were last saved
+func saveRooms() {
+	wss.Range(func(key, value interface{}) bool {
+		room := value.(*Room)
+		room.mutex.Lock()
+		if !room.dirty {
+			room.mutex.Unlock()
+			return true
+		}
+		room.lastActive = time.Now().UnixMilli()
+		if !validJobId(key.(string)) {
+			room.dirty = false
+			room.mutex.Unlock()
+			return true
+		}
+		room.trimChats()
+		content := room.stored()
+		room.dirty = false
+		room.mutex.Unlock()
+		if err := store.Save(key.(string), content); err != nil {
+			discord.Errorf("error saving room %s: %v", key, err)
+			room.mutex.Lock()
+			room.dirty = true
+			room.mutex.Unlock()
+		}
+		return true
+	})
+}
+
+// expireRooms drops the rooms that have been empty and unchanged for longer than config.RoomTTL,
+// from wss and from the store
+func expireRooms() {
+	now := time.Now().UnixMilli()
+	wss.Range(func(key, value interface{}) bool {
+		room := value.(*Room)
+		room.mutex.Lock()
+		if room.dirty || !room.idle(now) {
+			room.mutex.Unlock()
+			return true
+		}
+		room.expired = true
+		wss.Delete(key)
+		room.mutex.Unlock()
+		if err := store.Delete(key.(string)); err != nil {
+			discord.Errorf("error deleting room %s: %v", key, err)
+		}
+		return true
+	})
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"testing"
+	"time"
+)
+
+func TestChatsBefore(t *testing.T) {
+	room := newRoom("room")
+	// every chat is sent in the same millisecond, the cursor still has to page through all of them
+	for i := 0; i < 5; i++ {
+		room.Chats = append(room.Chats, &discord.Chat{Message: string(rune('a' + i)), Timestamp: 1000})
+	}
+	room.numberChats()
+	var messages string
+	cursor := int64(0)
+	for pages := 0; ; pages++ {
+		if pages > 5 {
+			t.Fatalf("Paging did not end")
+		}
+		var page []*discord.Chat
+		page, cursor = room.chatsBefore(cursor, 2)
+		for i := len(page) - 1; i >= 0; i-- {
+			messages += page[i].Message
+		}
+		if cursor == 0 {
+			break
+		}
+	}
+	if messages != "edcba" {
+		t.Errorf("Expected every chat once, newest first, got %q", messages)
+	}
+	if page, _ := room.chatsBefore(1, 2); len(page) != 0 {
+		t.Errorf("Expected no chats before the first one, got %d", len(page))
+	}
+}
+
+func TestNumberChats(t *testing.T) {
+	room := newRoom("room")
+	room.lastChatId = 3
+	room.Chats = append(room.Chats, &discord.Chat{}, &discord.Chat{}, &discord.Chat{Id: 9})
+	room.numberChats()
+	for i, want := range []int64{4, 5, 9} {
+		if room.Chats[i].Id != want {
+			t.Errorf("Expected id %d for chat %d, got %d", want, i, room.Chats[i].Id)
+		}
+	}
+	if room.lastChatId != 9 {
+		t.Errorf("Expected the last id to be 9, got %d", room.lastChatId)
+	}
+}
+
+func TestTrimChats(t *testing.T) {
+	config.TheConfig.ChatHistoryLimit = 3
+	config.TheConfig.ChatHistoryMaxAge = time.Hour
+	now := time.Now()
+	room := newRoom("room")
+	for _, age := range []time.Duration{3 * time.Hour, 2 * time.Hour, 30 * time.Minute, 20 * time.Minute, time.Minute} {
+		room.Chats = append(room.Chats, &discord.Chat{Timestamp: now.Add(-age).UnixMilli()})
+	}
+	room.trimChats()
+	if len(room.Chats) != 3 || room.Chats[0].Timestamp != now.Add(-30*time.Minute).UnixMilli() {
+		t.Errorf("Expected the 3 chats of the last hour, got %d", len(room.Chats))
+	}
+	config.TheConfig.ChatHistoryLimit = 2
+	room.trimChats()
+	if len(room.Chats) != 2 || room.Chats[1].Timestamp != now.Add(-time.Minute).UnixMilli() {
+		t.Errorf("Expected the 2 newest chats, got %d", len(room.Chats))
+	}
+}
+
+func TestIdleRooms(t *testing.T) {
+	config.TheConfig.RoomTTL = time.Hour
+	now := time.Now().UnixMilli()
+	room := newRoom("room")
+	if room.idle(now) {
+		t.Errorf("A new room is idle")
+	}
+	room.lastActive = now - 2*time.Hour.Milliseconds()
+	if !room.idle(now) {
+		t.Errorf("An empty room unused for 2 hours is not idle")
+	}
+	room.Players["player"] = &Player{}
+	if room.idle(now) {
+		t.Errorf("A room with players is idle")
+	}
+	config.TheConfig.RoomTTL = 0
+	delete(room.Players, "player")
+	if room.idle(now) {
+		t.Errorf("Rooms expire without a maximum age")
+	}
+}
@@ -15,10 +15,18 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
-func processFile(file os.DirEntry, parent string, te target.ToEncode) bool {
+// processFile queues a job for a new or changed file, jobs run and complete on the queue's workers so it
+// never reports a file as processed, completed jobs are counted by runJob
+func processFile(file os.DirEntry, root, parent string, te target.ToEncode) bool {
+	ctx := cleanup.Context()
+	if ctx.Err() != nil {
+		return false
+	}
 	ext := filepath.Ext(file.Name())
 	if slices.Contains(job.ValidExtensions, ext[1:]) {
 		jobs, err := job.JobsCache.Get(false)
@@ -33,48 +41,70 @@ func processFile(file os.DirEntry, parent string, te target.ToEncode) bool {
 		}
 		currId := utils.GetTitleId(file.Name())
 		log.Debugf("Current ID: %s", currId)
+		var resumed *job.Job
 		for _, j := range jobs {
 			prevId := utils.GetTitleId(j.Input)
 			if currId == prevId {
 				log.Debugf("File exists: %s", file.Name())
 				if j.State == job.Complete && len(j.EncodedCodecs) > 0 &&
 					(j.OriSize == 0 || j.OriSize == stats.Size()) &&
-					(j.Fast == te.Fast) && (j.Translate == te.Translate) {
+					(j.Fast == te.Fast) && (j.Translate == te.Translate) &&
+					!j.TranslationDue() {
 					return false
-				} else {
-					discord.Infof("File modified or prev encoding incomplete: %s, remove old", file.Name())
-					err := os.RemoveAll(utils.OutputJoin(j.Id))
-					if err != nil {
-						discord.Errorf("error removing file: %v", err)
+				}
+				if resumed == nil {
+					prev, err := job.Load(j.Id)
+					if err == nil && prev.Resumable(root, parent, file.Name(), stats.Size(), te.Fast, te.Translate) {
+						discord.Infof("Resuming incomplete job %s: %s, completed steps: %v", prev.Id, file.Name(), prev.Steps)
+						resumed = prev
+						continue
 					}
 				}
+				discord.Infof("File modified or prev encoding incomplete: %s, remove old", file.Name())
+				err := os.RemoveAll(utils.OutputJoin(j.Id))
+				if err != nil {
+					discord.Errorf("error removing file: %v", err)
+				}
 			}
 		}
-		j := job.Job{
-			Id:          target.NewRandomString(5),
-			InputParent: parent,
-			Input:       file.Name(),
-			OriSize:     stats.Size(),
-			OriModTime:  stats.ModTime().Unix(),
-			Fast:        te.Fast,
-			Translate:   te.Translate,
-		}
-		startTime := time.Now()
-		discord.Infof("Processing file: %s", file.Name())
-		err = j.Pipeline()
-		if err != nil {
-			discord.Errorf("error processing file: %v", err)
-		} else {
-			totalProcessed++
+		j := resumed
+		if j == nil {
+			j = &job.Job{
+				Id:          target.NewRandomString(5),
+				InputRoot:   root,
+				InputParent: parent,
+				Input:       file.Name(),
+				OriSize:     stats.Size(),
+				OriModTime:  stats.ModTime().Unix(),
+				Fast:        te.Fast,
+				Translate:   te.Translate,
+			}
 		}
-		discord.Infof("Processed %s, time cost: %s", file.Name(), time.Since(startTime))
-		if j.State == job.Complete {
-			return true
+		if j.InputRoot == "" {
+			j.InputRoot = root
 		}
+		queue.Submit(j)
+		return false
 	}
 	return false
 }
 
+func runJob(j *job.Job) {
+	ctx := cleanup.Context()
+	if ctx.Err() != nil {
+		return
+	}
+	startTime := time.Now()
+	discord.Infof("Processing file: %s", j.Input)
+	err := j.Pipeline(ctx)
+	if err != nil {
+		discord.Errorf("error processing file: %v", err)
+	} else {
+		totalProcessed.Add(1)
+	}
+	discord.Infof("Processed %s, time cost: %s", j.Input, time.Since(startTime))
+}
+
 func parseExtraParams(keyword string) (target.ToEncode, string) {
 	te := target.ToEncode{
 		Fast:      false,
@@ -91,10 +121,14 @@ func parseExtraParams(keyword string) (target.ToEncode, string) {
 	return te, keyword
 }
 
-var totalProcessed = 0
+var totalProcessed atomic.Int64
+var running sync.WaitGroup
+var queue *job.Queue
 
 func process() {
-	totalProcessed = 0
+	running.Add(1)
+	defer running.Done()
+	totalProcessed.Store(0)
 	target.SMMutex.Lock()
 	defer target.SMMutex.Unlock()
 	if len(target.Shows) == 0 && len(target.Movies) == 0 {
@@ -125,13 +159,15 @@ func process() {
 		discord.Infof(utils.AsJsonNoFormat(movie))
 		movies = append(movies, movie)
 	}
+	queue = job.NewQueue(config.TheConfig.JobWorkers, runJob)
 	for _, root := range config.TheConfig.ShowDirs {
 		target.LoopShows(root, shows, processFile)
 	}
 	for _, root := range config.TheConfig.MovieDirs {
 		target.LoopMovies(root, movies, processFile)
 	}
-	discord.Infof("Total processed: %d", totalProcessed)
+	queue.Close()
+	discord.Infof("Total processed: %d", totalProcessed.Load())
 	totalDeleted := 0
 	if config.TheConfig.EnableCleanup {
 		discord.Infof("Cleaning up old files")
@@ -141,6 +177,7 @@ func process() {
 			return
 		}
 		for _, j := range jobs {
+			// incomplete jobs that are still requested are kept, so the next scan resumes them
 			markedForRemoval := true
 			for _, show := range shows {
 				if strings.Contains(strings.ToLower(j.Input), strings.ToLower(show.Name)) {
@@ -152,9 +189,6 @@ func process() {
 					markedForRemoval = false
 				}
 			}
-			if j.State != job.Complete {
-				markedForRemoval = true
-			}
 			if markedForRemoval {
 				discord.Infof("File: %s, remove old, %s", utils.OutputJoin(j.Id), j.Input)
 				err := os.RemoveAll(utils.OutputJoin(j.Id))
@@ -168,7 +202,7 @@ func process() {
 		discord.Infof("Total deleted: %d", totalDeleted)
 	}
 
-	if (totalProcessed > 0 || totalDeleted > 0) && len(config.TheConfig.PurgeCacheUrl) > 0 {
+	if (totalProcessed.Load() > 0 || totalDeleted > 0) && len(config.TheConfig.PurgeCacheUrl) > 0 {
 		_, err := http.Get(config.TheConfig.PurgeCacheUrl)
 		if err != nil {
 			discord.Errorf("error purging cache: %v", err)
@@ -198,4 +232,14 @@ func main() {
 	}))
 	scheduler.StartAsync()
 	<-blocking
+	done := make(chan struct{})
+	go func() {
+		running.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(config.TheConfig.ShutdownTimeout):
+		discord.Errorf("Encoder did not stop within %s, exiting anyway", config.TheConfig.ShutdownTimeout)
+	}
 }
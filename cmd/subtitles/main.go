@@ -9,6 +9,8 @@ import (
 	"Sparkle/target"
 	"Sparkle/translation"
 	"Sparkle/utils"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/go-co-op/gocron"
 	log "github.com/sirupsen/logrus"
@@ -57,7 +59,7 @@ func process() {
 	}
 }
 
-func skip(j job.Job) bool {
+func skip(j *job.Job) bool {
 	for _, subtitleType := range config.TheConfig.TranslationSubtitleTypes {
 		for _, languageWithCode := range config.TheConfig.TranslationLanguages {
 			ss := strings.Split(languageWithCode, ";")
@@ -82,7 +84,7 @@ func skip(j job.Job) bool {
 	return true
 }
 
-func pipeline(j job.Job) error {
+func pipeline(ctx context.Context, j *job.Job) error {
 	if skip(j) {
 		log.Debugf("Skipping: %s", j.Input)
 		return nil
@@ -92,7 +94,7 @@ func pipeline(j job.Job) error {
 		return err
 	}
 	source := j.InputJoin(j.Input)
-	translatable, err := job.ContainsTranslatableSubtitles(source)
+	translatable, err := job.ContainsTranslatableSubtitles(ctx, source)
 	if err != nil {
 		return err
 	}
@@ -100,7 +102,7 @@ func pipeline(j job.Job) error {
 		return fmt.Errorf("%s doesn't contain translatable subtitle", source)
 	}
 	discord.Infof("Extracting subtitles: %s", source)
-	err = j.ExtractStreams(source, job.SubtitlesType)
+	err = j.ExtractStreams(ctx, source, job.SubtitlesType)
 	if err != nil {
 		return err
 	}
@@ -111,7 +113,7 @@ func pipeline(j job.Job) error {
 			dest := j.InputJoin(strings.ReplaceAll(j.Input, ".mkv",
 				fmt.Sprintf(".%s.%s", languageCode, subtitleType)))
 
-			err = translation.Translate(j.Input, j.OutputJoin(), source,
+			err = translation.Translate(ctx, j.Input, j.OutputJoin(), source,
 				dest, languageWithCode, subtitleType, false)
 			if err != nil {
 				discord.Errorf("Error translating: %v", err)
@@ -125,15 +127,26 @@ func pipeline(j job.Job) error {
 	return nil
 }
 
-func processFile(file os.DirEntry, parent string, _ target.ToEncode) bool {
+func processFile(file os.DirEntry, root, parent string, _ target.ToEncode) bool {
+	ctx := cleanup.Context()
+	if ctx.Err() != nil {
+		return false
+	}
 	ext := filepath.Ext(file.Name())
 	if slices.Contains(job.ValidExtensions, ext[1:]) {
-		j := job.Job{
+		j := &job.Job{
 			Id:          target.NewRandomString(5),
+			InputRoot:   root,
 			InputParent: parent,
 			Input:       file.Name(),
 		}
-		err := pipeline(j)
+		err := pipeline(ctx, j)
+		var exhausted *ai.ExhaustedError
+		if errors.As(err, &exhausted) {
+			// picked up again by the next scan once the quota is back
+			discord.Infof("Deferring %s: %v", j.Input, err)
+			return false
+		}
 		if err != nil {
 			discord.Errorf("Failed: %v", err)
 			return false
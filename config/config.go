@@ -8,28 +8,33 @@ import (
 )
 
 type Config struct {
-	Output                 string `env:"OUTPUT" envDefault:"./output"`
-	Input                  string `env:"INPUT" envDefault:"./input"`
-	Ffmpeg                 string `env:"FFMPEG" envDefault:"ffmpeg"`
-	Ffprobe                string `env:"FFPROBE" envDefault:"ffprobe"`
-	HandbrakeCli           string `env:"HANDBRAKE_CLI" envDefault:"./HandBrakeCLI"`
-	ConstantQuality        string `env:"CONSTANT_QUALITY" envDefault:"21"`
-	VideoExt               string `env:"VIDEO_EXT" envDefault:"mp4"`
-	Host                   string `env:"HOST" envDefault:"http://localhost"`
-	Encoder                string `env:"ENCODER" envDefault:"av1"`
-	Av1Encoder             string `env:"SVT_AV1_ENCODER" envDefault:"svt_av1_10bit"`
-	Av1Preset              string `env:"AV1_PRESET" envDefault:"6"`
-	HevcEncoder            string `env:"HEVC_ENCODER" envDefault:"nvenc_h265_10bit"`
-	HevcPreset             string `env:"HEVC_PRESET" envDefault:"slowest"`
-	H26410BitEncoder       string `env:"H264_ENCODER" envDefault:"x264_10bit"`
-	H26410BitPreset        string `env:"H264_PRESET" envDefault:"slow"`
-	H2648BitEncoder        string `env:"H264_ENCODER" envDefault:"x264"`
-	H2648BitPreset         string `env:"H264_PRESET" envDefault:"slow"`
-	H2648BitProfile        string `env:"H264_PROFILE" envDefault:"baseline"`
-	H2648BitTune           string `env:"H264_TUNE" envDefault:"fastdecode"`
-	ThumbnailHeight        int    `env:"THUMBNAIL_HEIGHT" envDefault:"320"`
-	ThumbnailInterval      int    `env:"THUMBNAIL_INTERVAL" envDefault:"2"`
-	ThumbnailChunkInterval int    `env:"THUMBNAIL_CHUNK_INTERVAL" envDefault:"1152"`
+	Output                 string   `env:"OUTPUT" envDefault:"./output"`
+	Input                  string   `env:"INPUT" envDefault:"./input"`
+	DataDir                string   `env:"DATA_DIR" envDefault:"./data"` // glossaries and other state kept across jobs
+	Ffmpeg                 string   `env:"FFMPEG" envDefault:"ffmpeg"`
+	Ffprobe                string   `env:"FFPROBE" envDefault:"ffprobe"`
+	HandbrakeCli           string   `env:"HANDBRAKE_CLI" envDefault:"./HandBrakeCLI"`
+	EncoderBackend         []string `env:"ENCODER_BACKEND" envDefault:"handbrake"` // handbrake | ffmpeg | av1=ffmpeg,hevc=handbrake
+	FfmpegFilters          string   `env:"FFMPEG_FILTERS" envDefault:""`
+	ConstantQuality        string   `env:"CONSTANT_QUALITY" envDefault:"21"`
+	Ladder                 []string `env:"LADDER" envDefault:""` // 1080:21,720:23,480:25
+	VideoExt               string   `env:"VIDEO_EXT" envDefault:"mp4"`
+	Host                   string   `env:"HOST" envDefault:"http://localhost"`
+	Encoder                string   `env:"ENCODER" envDefault:"av1"`
+	Av1Encoder             string   `env:"SVT_AV1_ENCODER" envDefault:"svt_av1_10bit"`
+	Av1Preset              string   `env:"AV1_PRESET" envDefault:"6"`
+	HevcEncoder            string   `env:"HEVC_ENCODER" envDefault:"nvenc_h265_10bit"`
+	HevcPreset             string   `env:"HEVC_PRESET" envDefault:"slowest"`
+	H26410BitEncoder       string   `env:"H264_ENCODER" envDefault:"x264_10bit"`
+	H26410BitPreset        string   `env:"H264_PRESET" envDefault:"slow"`
+	H2648BitEncoder        string   `env:"H264_ENCODER" envDefault:"x264"`
+	H2648BitPreset         string   `env:"H264_PRESET" envDefault:"slow"`
+	H2648BitProfile        string   `env:"H264_PROFILE" envDefault:"baseline"`
+	H2648BitTune           string   `env:"H264_TUNE" envDefault:"fastdecode"`
+	ThumbnailHeight        int      `env:"THUMBNAIL_HEIGHT" envDefault:"320"`
+	ThumbnailInterval      int      `env:"THUMBNAIL_INTERVAL" envDefault:"2"`
+	ThumbnailChunkInterval int      `env:"THUMBNAIL_CHUNK_INTERVAL" envDefault:"1152"`
+	HlsSegmentDuration     int      `env:"HLS_SEGMENT_DURATION" envDefault:"6"`
 
 	EnableEncode               bool `env:"ENABLE_ENCODE" envDefault:"true"`
 	EnableSprite               bool `env:"ENABLE_SPRITE" envDefault:"true"`
@@ -37,6 +42,7 @@ type Config struct {
 	EnableAttachmentExtraction bool `env:"ENABLE_ATTACHMENT_EXTRACTION" envDefault:"true"`
 	EnableLowPriority          bool `env:"ENABLE_LOW_PRIORITY" envDefault:"true"`
 	EnableCleanup              bool `env:"ENABLE_CLEANUP" envDefault:"true"`
+	EnableHls                  bool `env:"ENABLE_HLS" envDefault:"true"`
 
 	DiscordName         string   `env:"DISCORD_NAME" envDefault:"Encoding"`
 	DiscordWebhookError string   `env:"DISCORD_WEBHOOK_ERROR" envDefault:""`
@@ -46,21 +52,53 @@ type Config struct {
 	ShowDirs            []string `env:"SHOW_DIR" envDefault:""`
 	MovieDirs           []string `env:"MOVIE_DIR" envDefault:""`
 
-	ScanConfigInterval time.Duration `env:"SCAN_CONFIG_INTERVAL" envDefault:"1h"`
-	ScanInputInterval  time.Duration `env:"SCAN_INPUT_INTERVAL" envDefault:"3h"`
+	ScanConfigInterval   time.Duration `env:"SCAN_CONFIG_INTERVAL" envDefault:"1h"`
+	ScanInputInterval    time.Duration `env:"SCAN_INPUT_INTERVAL" envDefault:"3h"`
+	ShutdownTimeout      time.Duration `env:"SHUTDOWN_TIMEOUT" envDefault:"30s"`
+	SyncThreshold        float64       `env:"SYNC_THRESHOLD" envDefault:"2"`          // seconds a watch room player may drift, rooms can override it
+	ChatHistoryLimit     int           `env:"CHAT_HISTORY_LIMIT" envDefault:"500"`    // chats kept per room
+	ChatHistoryMaxAge    time.Duration `env:"CHAT_HISTORY_MAX_AGE" envDefault:"720h"` // and how long
+	ChatPageSize         int           `env:"CHAT_PAGE_SIZE" envDefault:"50"`
+	RoomTTL              time.Duration `env:"ROOM_TTL" envDefault:"720h"` // how long an empty, unused watch room is kept
+	JobWorkers           int           `env:"JOB_WORKERS" envDefault:"2"`
+	EncodeConcurrency    int           `env:"ENCODE_CONCURRENCY" envDefault:"2"`
+	ExtractConcurrency   int           `env:"EXTRACT_CONCURRENCY" envDefault:"2"`
+	TranslateConcurrency int           `env:"TRANSLATE_CONCURRENCY" envDefault:"1"`
+	EnableIntroDetection bool          `env:"ENABLE_INTRO_DETECTION" envDefault:"true"`
+	EnableLoudnorm       bool          `env:"ENABLE_LOUDNORM" envDefault:"false"`
+	LoudnormIntegrated   float64       `env:"LOUDNORM_INTEGRATED" envDefault:"-23"` // EBU R128 target in LUFS
+	LoudnormTruePeak     float64       `env:"LOUDNORM_TRUE_PEAK" envDefault:"-1"`
+	LoudnormRange        float64       `env:"LOUDNORM_RANGE" envDefault:"7"`
+	EnableNightMode      bool          `env:"ENABLE_NIGHT_MODE" envDefault:"false"`
+	EnableSdrTonemap     bool          `env:"ENABLE_SDR_TONEMAP" envDefault:"true"`
 
-	PurgeCacheUrl            string   `env:"PURGE_CACHE_URL" envDefault:""`
-	OpenAI                   string   `env:"OPENAI" envDefault:""`
-	Gemini                   []string `env:"GEMINI" envDefault:""`
-	AiProvider               string   `env:"AI_PROVIDER" envDefault:"gemini"`
-	OpenAIModel              string   `env:"OPENAI_MODEL" envDefault:"o4-mini"`
-	GeminiModel              string   `env:"GEMINI_MODEL" envDefault:"gemini-2.5-pro"`
-	TranslationLanguages     []string `env:"TRANSLATION_LANGUAGES" envDefault:"SIMPLIFIED Chinese;chi,Turkish;tur"` // Turkish;tur,Spanish;spa
-	TranslationOutputCutoff  float64  `env:"TRANSLATION_OUTPUT_CUTOFF" envDefault:"0.98"`
-	TranslationSubtitleTypes []string `env:"TRANSLATION_SUBTITLE_TYPES" envDefault:"ass"`
-	TranslationBatchLength   int      `env:"TRANSLATION_BATCH_LENGTH" envDefault:"36000"`
-	TranslationAttempts      int      `env:"TRANSLATION_ATTEMPTS" envDefault:"3"`
-	TranslationInputLanguage []string `env:"TRANSLATION_INPUT_LANGUAGE" envDefault:"jpn,eng"`
+	PurgeCacheUrl            string        `env:"PURGE_CACHE_URL" envDefault:""`
+	OpenAI                   string        `env:"OPENAI" envDefault:""`
+	Gemini                   []string      `env:"GEMINI" envDefault:""`
+	AiProvider               []string      `env:"AI_PROVIDER" envDefault:"gemini,openai,local"` // fallback chain, tried in order
+	AiExhaustedCooldown      time.Duration `env:"AI_EXHAUSTED_COOLDOWN" envDefault:"1h"`        // a key out of quota is skipped this long
+	AiUnavailableCooldown    time.Duration `env:"AI_UNAVAILABLE_COOLDOWN" envDefault:"15m"`     // and an overloaded one this long
+	OpenAIModel              string        `env:"OPENAI_MODEL" envDefault:"o4-mini"`
+	OpenAIBaseURL            string        `env:"OPENAI_BASE_URL" envDefault:""`
+	LocalAIBaseURL           string        `env:"LOCAL_AI_BASE_URL" envDefault:""` // e.g. http://localhost:11434/v1 for Ollama
+	LocalAIModel             string        `env:"LOCAL_AI_MODEL" envDefault:""`
+	LocalAIKey               string        `env:"LOCAL_AI_KEY" envDefault:"local"`
+	GeminiModel              string        `env:"GEMINI_MODEL" envDefault:"gemini-2.5-pro"`
+	TranslationLanguages     []string      `env:"TRANSLATION_LANGUAGES" envDefault:"SIMPLIFIED Chinese;chi,Turkish;tur"` // Turkish;tur,Spanish;spa
+	TranslationOutputCutoff  float64       `env:"TRANSLATION_OUTPUT_CUTOFF" envDefault:"0.98"`
+	TranslationSubtitleTypes []string      `env:"TRANSLATION_SUBTITLE_TYPES" envDefault:"ass"` // ass, vtt or srt
+	TranslationBatchLength   int           `env:"TRANSLATION_BATCH_LENGTH" envDefault:"36000"`
+	TranslationAttempts      int           `env:"TRANSLATION_ATTEMPTS" envDefault:"3"`
+	TranslationInputLanguage []string      `env:"TRANSLATION_INPUT_LANGUAGE" envDefault:"jpn,eng"`
+	TranslationMode          string        `env:"TRANSLATION_MODE" envDefault:"fragment"` // fragment or lines
+	EnableSeasonContext      bool          `env:"ENABLE_SEASON_CONTEXT" envDefault:"true"`
+	SeasonContextEpisodes    int           `env:"SEASON_CONTEXT_EPISODES" envDefault:"5"`   // previous episode summaries in the prompt
+	SeasonContextNotes       int           `env:"SEASON_CONTEXT_NOTES" envDefault:"40"`     // characters and terms each, the latest episodes' first
+	EnableDualSubtitles      bool          `env:"ENABLE_DUAL_SUBTITLES" envDefault:"false"` // also writes <code>.dual.<ext> with the original on top
+	EnableQualityPass        bool          `env:"ENABLE_QUALITY_PASS" envDefault:"true"`
+	ReadingLimits            []string      `env:"READING_LIMITS" envDefault:"*:20:42,chi:9:16,jpn:4:13,tur:17:42"` // code:cps:line length
+	EnableTranslationMemory  bool          `env:"ENABLE_TRANSLATION_MEMORY" envDefault:"true"`                     // reuses translated lines, only in lines mode since fragments carry timings
+	TranslationMemoryLimit   int           `env:"TRANSLATION_MEMORY_LIMIT" envDefault:"50000"`                     // lines per language pair, the oldest are dropped
 
 	OverSeerrURL     string `env:"OVERSEERR_URL" envDefault:"http://localhost"`
 	OverSeerrAPI     string `env:"OVERSEERR_API" envDefault:""`
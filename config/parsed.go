@@ -21,19 +21,72 @@ Task:
 4. Translate ONLY the input fragment; do not add any missing headers, footers, or other content.
 Output: A single, valid fragment of .ass as plain text—no markdown, notes, or comments—identical in structure to the input, with dialogue text now in %s.`
 
+const systemMessageLines = `You are an intelligent subtitle translator.
+Input: A JSON object whose "lines" array holds subtitle lines in one foreign (%s) language, each with a numeric "id" and its "text".
+Media: %s.
+Task:
+1. Replace the text of each line with a fluent, context‑aware %s translation, except for segments that are intentionally left untranslated.
+2. Keep every id exactly as given. Do NOT omit, merge, split or add lines, even when a sentence continues over several lines.
+3. Keep line breaks inside a text as they are, and keep placeholders such as {1} next to the words they belong to.
+Output: A JSON object with a "lines" array holding every input id once, with its text now in %s.`
+
+const systemMessageContext = `You keep notes for the subtitle translators of a series.
+Input: The dialogue of one episode, one subtitle line per row.
+Media: %s.
+Task:
+1. List the characters that appear or are mentioned, each as "name: one short description".
+2. List recurring names of places, groups, techniques and other terms worth translating consistently.
+3. Summarize the plot of the episode in at most 80 words.
+Output: A JSON object with "characters", "terms" and "summary", written in the language of the dialogue.`
+
+const systemMessageShorten = `You are an intelligent subtitle editor.
+Input: A JSON object whose "lines" array holds %s subtitle lines that are too long to read in time, each with a numeric "id", its "text" and the "max_characters" it may use.
+Media: %s.
+Task:
+1. Rewrite the text of each line in %s so it uses at most max_characters characters and no row is longer than %d characters, keeping the meaning and tone.
+2. Drop filler words and repetitions first, split a long row with a line break rather than cutting content where possible, and use at most two rows.
+3. Keep every id exactly as given. Do NOT omit, merge, split or add lines. Keep placeholders such as {1}.
+Output: A JSON object with a "lines" array holding every input id once with its shortened text.`
+
 const (
 	WEBVTT = iota // 0
 	ASS           // 1
+	LINES         // 2, JSON lines of either format
 )
 
-func GetSystemMessage(inputLang, translationLanguage, media string, whichOne int) string {
+// GetContextSystemMessage builds the system message asking for the notes on an episode
+func GetContextSystemMessage(media string) string {
+	return fmt.Sprintf(systemMessageContext, media)
+}
+
+// GetShortenSystemMessage builds the system message asking to shorten translated lines over the reading limits
+func GetShortenSystemMessage(language, media string, maxLineLength int, sections ...string) string {
+	msg := fmt.Sprintf(systemMessageShorten, language, media, language, maxLineLength)
+	for _, section := range sections {
+		if section != "" {
+			msg += "\n\n" + section
+		}
+	}
+	return msg
+}
+
+// GetSystemMessage builds the system message for a subtitle format, non-empty sections such as a glossary are appended
+func GetSystemMessage(inputLang, translationLanguage, media string, whichOne int, sections ...string) string {
 	var msg string
 	if whichOne == ASS {
 		msg = systemMessageASS
 	} else if whichOne == WEBVTT {
 		msg = systemMessageWEBVTT
+	} else if whichOne == LINES {
+		msg = systemMessageLines
 	} else {
 		panic(fmt.Errorf("unknown subtitle type: %d", whichOne))
 	}
-	return fmt.Sprintf(msg, inputLang, media, translationLanguage, translationLanguage)
+	msg = fmt.Sprintf(msg, inputLang, media, translationLanguage, translationLanguage)
+	for _, section := range sections {
+		if section != "" {
+			msg += "\n\n" + section
+		}
+	}
+	return msg
 }
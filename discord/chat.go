@@ -6,6 +6,7 @@ import (
 )
 
 type Chat struct {
+	Id        int64   `json:"id,omitempty"` // increasing within a room, the cursor of the chat history
 	Message   string  `json:"message"`
 	Timestamp int64   `json:"timestamp"`
 	MediaSec  float64 `json:"mediaSec"`
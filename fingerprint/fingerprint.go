@@ -0,0 +1,261 @@
+package fingerprint
+
+import (
+	"encoding/binary"
+	"math"
+	"math/bits"
+	"math/cmplx"
+	"slices"
+)
+
+// Audio is expected as mono signed 16-bit little endian PCM at SampleRate, which is what
+// ffmpeg produces with "-ac 1 -ar 8000 -f s16le".
+const (
+	SampleRate = 8000
+	frameSize  = 1024
+	hopSize    = 512
+	bandCount  = 17
+	minFreq    = 300.0
+	maxFreq    = 2000.0
+	// silentHash marks frames too quiet to fingerprint, they never match anything
+	silentHash   = 1 << 31
+	silenceLevel = 1e-4
+)
+
+// HopSeconds is the time between two consecutive hashes of a Fingerprint
+const HopSeconds = float64(hopSize) / SampleRate
+
+// Fingerprint holds one 16-bit hash per hop, bit b tells whether the energy difference between
+// bands b and b+1 grew compared to the previous frame (Haitsma-Kalker).
+type Fingerprint []uint32
+
+// Duration returns the length of the fingerprinted audio in seconds
+func (f Fingerprint) Duration() float64 {
+	return float64(len(f)) * HopSeconds
+}
+
+// Decode converts raw s16le PCM to samples
+func Decode(pcm []byte) []int16 {
+	samples := make([]int16, len(pcm)/2)
+	for i := range samples {
+		samples[i] = int16(binary.LittleEndian.Uint16(pcm[i*2:]))
+	}
+	return samples
+}
+
+// Compute fingerprints mono samples recorded at SampleRate
+func Compute(samples []int16) Fingerprint {
+	if len(samples) < frameSize {
+		return nil
+	}
+	window := make([]float64, frameSize)
+	for i := range window {
+		window[i] = 0.5 - 0.5*math.Cos(2*math.Pi*float64(i)/float64(frameSize-1))
+	}
+	edges := bandEdges()
+	frames := (len(samples)-frameSize)/hopSize + 1
+	fp := make(Fingerprint, frames)
+	buf := make([]complex128, frameSize)
+	prev := make([]float64, bandCount)
+	curr := make([]float64, bandCount)
+	for f := 0; f < frames; f++ {
+		offset := f * hopSize
+		var total float64
+		for i := 0; i < frameSize; i++ {
+			s := float64(samples[offset+i]) / 32768
+			total += s * s
+			buf[i] = complex(s*window[i], 0)
+		}
+		fft(buf)
+		for b := 0; b < bandCount; b++ {
+			var energy float64
+			for k := edges[b]; k < edges[b+1]; k++ {
+				energy += real(buf[k])*real(buf[k]) + imag(buf[k])*imag(buf[k])
+			}
+			curr[b] = energy
+		}
+		if total/frameSize < silenceLevel*silenceLevel {
+			fp[f] = silentHash
+		} else {
+			var hash uint32
+			for b := 0; b < bandCount-1; b++ {
+				if (curr[b]-curr[b+1])-(prev[b]-prev[b+1]) > 0 {
+					hash |= 1 << b
+				}
+			}
+			fp[f] = hash
+		}
+		prev, curr = curr, prev
+	}
+	return fp
+}
+
+// bandEdges returns the FFT bins delimiting bandCount logarithmically spaced bands
+func bandEdges() []int {
+	edges := make([]int, bandCount+1)
+	for b := range edges {
+		freq := minFreq * math.Pow(maxFreq/minFreq, float64(b)/bandCount)
+		edges[b] = int(freq * frameSize / SampleRate)
+	}
+	return edges
+}
+
+// fft is an in-place iterative radix-2 transform, len(a) must be a power of two
+func fft(a []complex128) {
+	n := len(a)
+	for i, j := 1, 0; i < n; i++ {
+		bit := n >> 1
+		for ; j&bit != 0; bit >>= 1 {
+			j ^= bit
+		}
+		j |= bit
+		if i < j {
+			a[i], a[j] = a[j], a[i]
+		}
+	}
+	for size := 2; size <= n; size <<= 1 {
+		step := cmplx.Exp(complex(0, -2*math.Pi/float64(size)))
+		for start := 0; start < n; start += size {
+			w := complex(1, 0)
+			for k := 0; k < size/2; k++ {
+				u := a[start+k]
+				v := a[start+k+size/2] * w
+				a[start+k] = u + v
+				a[start+k+size/2] = u - v
+				w *= step
+			}
+		}
+	}
+}
+
+// Match is a stretch of audio found in both fingerprints, times are in seconds
+type Match struct {
+	StartA float64
+	EndA   float64
+	StartB float64
+	EndB   float64
+}
+
+func (m Match) Duration() float64 {
+	return m.EndA - m.StartA
+}
+
+const (
+	maxBitErrors   = 4   // hashes differing in at most this many bits are considered equal
+	maxCandidates  = 8   // offsets checked in detail
+	commonHash     = 64  // hashes occurring more often than this carry no information
+	matchWindow    = 32  // frames looked at when deciding if a frame belongs to a match
+	minMatchRatio  = 0.4 // share of matching frames in the window
+	offsetTolerant = 2   // offsets this close to a better candidate are the same alignment
+)
+
+// Matches finds the stretches of at least minDuration seconds that a and b have in common,
+// sorted by their start in a.
+func Matches(a, b Fingerprint, minDuration float64) []Match {
+	index := make(map[uint32][]int)
+	for j, h := range b {
+		if h != silentHash {
+			index[h] = append(index[h], j)
+		}
+	}
+	votes := make(map[int]int)
+	for i, h := range a {
+		positions := index[h]
+		if h == silentHash || len(positions) > commonHash {
+			continue
+		}
+		for _, j := range positions {
+			votes[j-i]++
+		}
+	}
+	offsets := make([]int, 0, len(votes))
+	for offset := range votes {
+		offsets = append(offsets, offset)
+	}
+	slices.SortFunc(offsets, func(x, y int) int {
+		return votes[y] - votes[x]
+	})
+
+	minFrames := int(minDuration / HopSeconds)
+	var matches []Match
+	var checked []int
+	for _, offset := range offsets {
+		if len(checked) >= maxCandidates || votes[offset] < minFrames/20 {
+			break
+		}
+		if slices.ContainsFunc(checked, func(c int) bool { return abs(c-offset) <= offsetTolerant }) {
+			continue
+		}
+		checked = append(checked, offset)
+		for _, m := range alignedRuns(a, b, offset, minFrames) {
+			if !slices.ContainsFunc(matches, func(o Match) bool { return overlaps(o, m) }) {
+				matches = append(matches, m)
+			}
+		}
+	}
+	slices.SortFunc(matches, func(x, y Match) int {
+		return int(x.StartA*1000) - int(y.StartA*1000)
+	})
+	return matches
+}
+
+// alignedRuns compares a[i] with b[i+offset] and returns the runs where enough frames agree
+func alignedRuns(a, b Fingerprint, offset, minFrames int) []Match {
+	start := max(0, -offset)
+	end := min(len(a), len(b)-offset)
+	if end-start < minFrames {
+		return nil
+	}
+	same := make([]bool, end-start)
+	for i := start; i < end; i++ {
+		ha, hb := a[i], b[i+offset]
+		same[i-start] = ha != silentHash && hb != silentHash && bits.OnesCount32(ha^hb) <= maxBitErrors
+	}
+	// prefix sums to get the matching share of every window cheaply
+	sums := make([]int, len(same)+1)
+	for i, s := range same {
+		sums[i+1] = sums[i]
+		if s {
+			sums[i+1]++
+		}
+	}
+	var runs []Match
+	runStart := -1
+	lastSame := -1
+	for i := 0; i <= len(same); i++ {
+		inRun := false
+		if i < len(same) {
+			lo, hi := max(0, i-matchWindow/2), min(len(same), i+matchWindow/2)
+			inRun = float64(sums[hi]-sums[lo]) >= minMatchRatio*float64(hi-lo)
+			if inRun && same[i] {
+				if runStart < 0 {
+					runStart = i
+				}
+				lastSame = i
+			}
+		}
+		if !inRun && runStart >= 0 {
+			if lastSame-runStart+1 >= minFrames {
+				runs = append(runs, Match{
+					StartA: float64(runStart+start) * HopSeconds,
+					EndA:   float64(lastSame+start+1) * HopSeconds,
+					StartB: float64(runStart+start+offset) * HopSeconds,
+					EndB:   float64(lastSame+start+offset+1) * HopSeconds,
+				})
+			}
+			runStart = -1
+		}
+	}
+	return runs
+}
+
+func overlaps(x, y Match) bool {
+	return x.StartA < y.EndA && y.StartA < x.EndA
+}
+
+func abs(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
@@ -0,0 +1,52 @@
+package fingerprint
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+// melody renders seconds of random notes, a stand-in for music that is deterministic per seed
+func melody(seed int64, seconds float64) []int16 {
+	r := rand.New(rand.NewSource(seed))
+	samples := make([]int16, int(seconds*SampleRate))
+	noteLength := SampleRate / 4
+	var freqs [3]float64
+	for i := range samples {
+		if i%noteLength == 0 {
+			for k := range freqs {
+				freqs[k] = 200 + r.Float64()*1800
+			}
+		}
+		t := float64(i) / SampleRate
+		var v float64
+		for _, f := range freqs {
+			v += math.Sin(2 * math.Pi * f * t)
+		}
+		samples[i] = int16(v / 3 * 8000)
+	}
+	return samples
+}
+
+func TestMatchesSharedSegment(t *testing.T) {
+	intro := melody(1, 90)
+	// the intro starts at a different, non hop aligned, position in each episode
+	a := append(append(melody(2, 30.01), intro...), melody(3, 120)...)
+	b := append(append(melody(4, 75.37), intro...), melody(5, 100)...)
+
+	matches := Matches(Compute(a), Compute(b), 20)
+	if len(matches) != 1 {
+		t.Fatalf("expected 1 match, got %d: %+v", len(matches), matches)
+	}
+	m := matches[0]
+	if math.Abs(m.StartA-30.01) > 1 || math.Abs(m.StartB-75.37) > 1 || math.Abs(m.Duration()-90) > 2 {
+		t.Errorf("unexpected match: %+v", m)
+	}
+}
+
+func TestMatchesUnrelated(t *testing.T) {
+	matches := Matches(Compute(melody(6, 180)), Compute(melody(7, 180)), 20)
+	if len(matches) != 0 {
+		t.Errorf("expected no match, got %+v", matches)
+	}
+}
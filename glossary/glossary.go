@@ -0,0 +1,158 @@
+package glossary
+
+import (
+	"Sparkle/config"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const dir = "glossary"
+
+var showIdRegex = regexp.MustCompile(`^[a-z0-9]+$`)
+
+// Glossary fixes how names and terms of a show are translated, Terms maps a target language code
+// (as in TRANSLATION_LANGUAGES) to source term -> translation.
+type Glossary struct {
+	Show  string                       `json:"show"`
+	Terms map[string]map[string]string `json:"terms"`
+}
+
+// Mismatch is a glossary term found in the source whose translation is missing from the output
+type Mismatch struct {
+	Term        string `json:"term"`
+	Translation string `json:"translation"`
+	Expected    int    `json:"expected"`
+	Found       int    `json:"found"`
+}
+
+var mutex sync.RWMutex
+
+func ValidShowId(show string) bool {
+	return showIdRegex.MatchString(show)
+}
+
+func path(show string) string {
+	return filepath.Join(config.TheConfig.DataDir, dir, show+".json")
+}
+
+// Load returns the glossary of a show, an empty one if none was saved yet
+func Load(show string) (*Glossary, error) {
+	if !ValidShowId(show) {
+		return nil, fmt.Errorf("invalid show id: %s", show)
+	}
+	mutex.RLock()
+	defer mutex.RUnlock()
+	g := &Glossary{Show: show, Terms: make(map[string]map[string]string)}
+	content, err := os.ReadFile(path(show))
+	if errors.Is(err, os.ErrNotExist) {
+		return g, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, g)
+	if err != nil {
+		return nil, err
+	}
+	g.Show = show
+	return g, nil
+}
+
+func Save(g *Glossary) error {
+	if !ValidShowId(g.Show) {
+		return fmt.Errorf("invalid show id: %s", g.Show)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	err := os.MkdirAll(filepath.Join(config.TheConfig.DataDir, dir), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(g, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path(g.Show), content, 0644)
+}
+
+func Delete(show string) error {
+	if !ValidShowId(show) {
+		return fmt.Errorf("invalid show id: %s", show)
+	}
+	mutex.Lock()
+	defer mutex.Unlock()
+	err := os.Remove(path(show))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// List returns the ids of every show with a glossary
+func List() ([]string, error) {
+	mutex.RLock()
+	defer mutex.RUnlock()
+	entries, err := os.ReadDir(filepath.Join(config.TheConfig.DataDir, dir))
+	if errors.Is(err, os.ErrNotExist) {
+		return []string{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	shows := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if show, ok := strings.CutSuffix(entry.Name(), ".json"); ok {
+			shows = append(shows, show)
+		}
+	}
+	return shows, nil
+}
+
+func (g *Glossary) sortedTerms(language string) []string {
+	terms := make([]string, 0, len(g.Terms[language]))
+	for term := range g.Terms[language] {
+		terms = append(terms, term)
+	}
+	sort.Strings(terms)
+	return terms
+}
+
+// Prompt returns the system message section enforcing the glossary, empty when there are no terms
+func (g *Glossary) Prompt(language string) string {
+	terms := g.sortedTerms(language)
+	if len(terms) == 0 {
+		return ""
+	}
+	var sb strings.Builder
+	sb.WriteString("Glossary: always translate these names and terms exactly as given, keep honorifics as listed.\n")
+	for _, term := range terms {
+		sb.WriteString(fmt.Sprintf("- %s => %s\n", term, g.Terms[language][term]))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// Check compares how often each glossary term appears in source with how often its translation appears in translated
+func (g *Glossary) Check(language, source, translated string) []Mismatch {
+	var mismatches []Mismatch
+	source = strings.ToLower(source)
+	translated = strings.ToLower(translated)
+	for _, term := range g.sortedTerms(language) {
+		expected := strings.Count(source, strings.ToLower(term))
+		if expected == 0 {
+			continue
+		}
+		translation := g.Terms[language][term]
+		found := strings.Count(translated, strings.ToLower(translation))
+		if found < expected {
+			mismatches = append(mismatches, Mismatch{Term: term, Translation: translation, Expected: expected, Found: found})
+		}
+	}
+	return mismatches
+}
@@ -0,0 +1,172 @@
+package job
+
+import (
+	"Sparkle/config"
+	"context"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	HandbrakeBackend = "handbrake"
+	FfmpegBackend    = "ffmpeg"
+)
+
+// encodeTarget is a single rendition to produce, Encoder/Preset/Profile/Tune use HandBrake's naming
+// which every backend translates to its own arguments.
+type encodeTarget struct {
+	Rendition Rendition
+	Encoder   string
+	Preset    string
+	Profile   string
+	Tune      string
+	Input     string
+	Output    string
+	Duration  float64
+	Tonemap   bool // convert HDR input to SDR
+}
+
+// EncoderBackend turns an encode target into a command and knows how to read its progress output
+type EncoderBackend interface {
+	Command(ctx context.Context, target encodeTarget) *exec.Cmd
+	Progress(job *Job, target encodeTarget) func(line string)
+}
+
+var encoderBackends = map[string]EncoderBackend{
+	HandbrakeBackend: handbrakeEncoder{},
+	FfmpegBackend:    ffmpegEncoder{},
+}
+
+// backendFor returns the backend configured for an encoder, entries are either a
+// backend name applying to every encoder or encoder=backend.
+func backendFor(encoder string) (EncoderBackend, error) {
+	name := HandbrakeBackend
+	for _, entry := range config.TheConfig.EncoderBackend {
+		s := strings.SplitN(strings.TrimSpace(entry), "=", 2)
+		if len(s) == 1 && s[0] != "" {
+			name = s[0]
+		} else if len(s) == 2 && s[0] == encoder {
+			name = s[1]
+			break
+		}
+	}
+	backend, ok := encoderBackends[strings.ToLower(name)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported encoder backend: %s", name)
+	}
+	return backend, nil
+}
+
+type handbrakeEncoder struct{}
+
+func (handbrakeEncoder) Command(ctx context.Context, target encodeTarget) *exec.Cmd {
+	args := []string{
+		"-i", target.Input,
+		"-o", target.Output,
+		"--encoder", target.Encoder,
+		"--vfr",
+		"--quality", target.Rendition.Quality,
+		"--encoder-preset", target.Preset,
+		"--subtitle", "none",
+		"--aencoder", "opus",
+		"--audio-lang-list", "any",
+		"--all-audio",
+		"--optimize", // web optimized
+		"--mixdown", "stereo"}
+	if target.Rendition.MaxHeight > 0 {
+		args = append(args, "--maxHeight", strconv.Itoa(target.Rendition.MaxHeight))
+	}
+	if target.Profile != "" {
+		args = append(args, "--encoder-profile", target.Profile)
+	}
+	if target.Tune != "" {
+		args = append(args, "--encoder-tune", target.Tune)
+	}
+	return exec.CommandContext(ctx, config.TheConfig.HandbrakeCli, args...)
+}
+
+func (handbrakeEncoder) Progress(job *Job, target encodeTarget) func(line string) {
+	return job.handbrakeProgress(target.Rendition.Name)
+}
+
+type ffmpegEncoder struct{}
+
+// ffmpegCodecs maps the encoder families to ffmpeg encoders
+var ffmpegCodecs = map[string]string{
+	"av1":        "libsvtav1",
+	"hevc":       "libx265",
+	"h264-10bit": "libx264",
+	"h264-8bit":  "libx264",
+}
+
+// ffmpegPreset translates HandBrake presets (including the nvenc ones) to x264/x265 presets,
+// SVT-AV1 presets are numeric on both sides and pass through.
+func ffmpegPreset(codec, preset string) string {
+	if codec == "libsvtav1" {
+		return preset
+	}
+	switch preset {
+	case "fastest":
+		return "veryfast"
+	case "slowest":
+		return "veryslow"
+	}
+	return preset
+}
+
+func (ffmpegEncoder) Command(ctx context.Context, target encodeTarget) *exec.Cmd {
+	codec := ffmpegCodecs[target.Rendition.Codec]
+	pixFmt := "yuv420p"
+	// the SDR copy is for compatibility, tonemapFilter ends in 8-bit so it stays 8-bit
+	if strings.Contains(target.Encoder, "10bit") && !target.Tonemap {
+		pixFmt = "yuv420p10le"
+	}
+	args := []string{
+		"-y",
+		"-i", target.Input,
+		"-map", "0:v:0",
+		"-map", "0:a?",
+		"-map_chapters", "0",
+		"-sn", "-dn",
+		"-c:v", codec,
+		"-preset", ffmpegPreset(codec, target.Preset),
+		"-crf", target.Rendition.Quality,
+		"-pix_fmt", pixFmt,
+		"-fps_mode", "passthrough",
+		"-c:a", "libopus",
+		"-b:a", "160k",
+		"-ac", "2",
+	}
+	if target.Profile != "" {
+		args = append(args, "-profile:v", target.Profile)
+	}
+	if target.Tune != "" {
+		args = append(args, "-tune", target.Tune)
+	}
+	var filters []string
+	if target.Tonemap {
+		filters = append(filters, tonemapFilter)
+		args = append(args, "-color_primaries", "bt709", "-color_trc", "bt709", "-colorspace", "bt709")
+	}
+	if target.Rendition.MaxHeight > 0 {
+		filters = append(filters, fmt.Sprintf("scale=-2:'min(ih,%d)'", target.Rendition.MaxHeight))
+	}
+	if config.TheConfig.FfmpegFilters != "" {
+		filters = append(filters, config.TheConfig.FfmpegFilters)
+	}
+	if len(filters) > 0 {
+		args = append(args, "-vf", strings.Join(filters, ","))
+	}
+	args = append(args,
+		"-movflags", "+faststart", // web optimized
+		"-progress", "pipe:1",
+		"-nostats",
+		target.Output)
+	return exec.CommandContext(ctx, config.TheConfig.Ffmpeg, args...)
+}
+
+func (ffmpegEncoder) Progress(job *Job, target encodeTarget) func(line string) {
+	return job.ffmpegProgress(target.Rendition.Name, StageEncode, target.Duration)
+}
@@ -0,0 +1,92 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"encoding/json"
+	"os/exec"
+	"regexp"
+	"sync"
+)
+
+// Dynamic ranges of the source and the renditions
+const (
+	RangeSdr         = "sdr"
+	RangeHdr10       = "hdr10"
+	RangeHlg         = "hlg"
+	RangeDolbyVision = "dolby_vision"
+	sdrSuffix        = "-sdr"
+)
+
+// tonemapFilter converts HDR video to BT.709 SDR, it has to run before scaling
+const tonemapFilter = "zscale=t=linear:npl=100,format=gbrpf32le,zscale=p=bt709," +
+	"tonemap=tonemap=hable:desat=0,zscale=t=bt709:m=bt709:r=tv,format=yuv420p"
+
+// zscaleRegex finds the zscale filter in the output of ffmpeg -filters
+var zscaleRegex = regexp.MustCompile(`(?m)^\s*\S+\s+zscale\s`)
+
+var tonemapProbe = struct {
+	sync.Once
+	available bool
+}{}
+
+// canTonemap tells whether the ffmpeg build has zscale (libzimg), which tonemapFilter needs, ffmpeg is probed once
+func canTonemap() bool {
+	tonemapProbe.Do(func() {
+		out, err := utils.RunCommand(exec.Command(config.TheConfig.Ffmpeg, "-hide_banner", "-filters"))
+		tonemapProbe.available = err == nil && zscaleRegex.Match(out)
+	})
+	return tonemapProbe.available
+}
+
+// DynamicRange classifies a video stream from its transfer characteristics and side data
+func (s StreamInfo) DynamicRange() string {
+	for _, sd := range s.SideDataList {
+		if sd.SideDataType == "DOVI configuration record" {
+			return RangeDolbyVision
+		}
+	}
+	switch s.ColorTransfer {
+	case "smpte2084":
+		return RangeHdr10
+	case "arib-std-b67":
+		return RangeHlg
+	}
+	return RangeSdr
+}
+
+// Hdr reports whether the rendition needs an HDR capable display
+func (r Rendition) Hdr() bool {
+	return r.DynamicRange != "" && r.DynamicRange != RangeSdr
+}
+
+// detectDynamicRange records the dynamic range of the first video stream of the input
+func (job *Job) detectDynamicRange(ctx context.Context) {
+	job.DynamicRange = RangeSdr
+	out, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "quiet", "-print_format", "json",
+		"-select_streams", "v:0", "-show_streams", job.InputJoin(job.Input)))
+	if err != nil {
+		discord.Errorf("Error getting source colour metadata: %v", err)
+		return
+	}
+	var probeOutput FFProbeOutput
+	err = json.Unmarshal(out, &probeOutput)
+	if err != nil || len(probeOutput.Streams) == 0 {
+		return
+	}
+	video := probeOutput.Streams[0]
+	job.DynamicRange = video.DynamicRange()
+	discord.Infof("Source dynamic range: %s (transfer %s, primaries %s)", job.DynamicRange, video.ColorTransfer, video.ColorPrimaries)
+}
+
+// previewCodec returns the rendition thumbnails are taken from, SDR ones look right everywhere
+func (job *Job) previewCodec() string {
+	for _, codec := range job.EncodedCodecs {
+		if r := job.GetRendition(codec); r != nil && !r.Hdr() {
+			return codec
+		}
+	}
+	return job.EncodedCodecs[0]
+}
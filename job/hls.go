@@ -0,0 +1,282 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const (
+	HlsDir          = "hls"
+	HlsMaster       = "master.m3u8"
+	hlsMedia        = "media.m3u8"
+	hlsInit         = "init.mp4"
+	hlsAudioGroup   = "audio"
+	hlsSubtitleGrp  = "subs"
+	hlsSegmentFiles = "seg_%05d.m4s"
+	hlsTimescale    = 90000 // MPEG-TS clock of X-TIMESTAMP-MAP
+)
+
+// hlsVideoRanges are the VIDEO-RANGE values of each dynamic range
+var hlsVideoRanges = map[string]string{
+	RangeSdr:         "SDR",
+	RangeHdr10:       "PQ",
+	RangeDolbyVision: "PQ",
+	RangeHlg:         "HLG",
+}
+
+// hlsCodecs are the RFC 6381 codec strings advertised for each encoder,
+// browsers use them to pick a variant they are able to decode.
+var hlsCodecs = map[string]string{
+	"av1":        "av01.0.08M.10",
+	"hevc":       "hvc1.2.4.L150.90",
+	"h264-10bit": "avc1.6e0028",
+	"h264-8bit":  "avc1.42e01e",
+}
+
+// segment packages the first stream of the given type in input into fMP4/CMAF segments under dir
+func (job *Job) segment(ctx context.Context, input, streamType, dir string) error {
+	err := os.MkdirAll(job.OutputJoin(HlsDir, dir), 0755)
+	if err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-y", "-i", input,
+		"-map", fmt.Sprintf("0:%s:0", streamType), "-c", "copy",
+		"-f", "hls",
+		"-hls_time", fmt.Sprintf("%d", config.TheConfig.HlsSegmentDuration),
+		"-hls_playlist_type", "vod",
+		"-hls_segment_type", "fmp4",
+		"-hls_fmp4_init_filename", hlsInit,
+		"-hls_segment_filename", job.OutputJoin(HlsDir, dir, hlsSegmentFiles),
+		job.OutputJoin(HlsDir, dir, hlsMedia))
+	discord.Infof("Command: %s", cmd.String())
+	_, err = utils.RunCommand(cmd)
+	return err
+}
+
+// bandwidth estimates the peak bitrate of the segments in dir in bits per second
+func (job *Job) bandwidth(dir string) int64 {
+	entries, err := os.ReadDir(dir)
+	if err != nil || job.Duration <= 0 {
+		return 0
+	}
+	var size int64
+	for _, entry := range entries {
+		if info, err := entry.Info(); err == nil && !entry.IsDir() {
+			size += info.Size()
+		}
+	}
+	return int64(math.Ceil(float64(size*8) / job.Duration))
+}
+
+// segmentAudio packages every extracted audio stream, the audio files are removed once the audio
+// step is done so the renditions are segmented right away instead of with the video
+func (job *Job) segmentAudio(ctx context.Context) {
+	for _, audio := range job.Streams {
+		if audio.CodecType != AudioType {
+			continue
+		}
+		dir := "audio-" + audio.Id()
+		err := os.RemoveAll(job.OutputJoin(HlsDir, dir))
+		if err == nil {
+			err = job.segment(ctx, job.OutputJoin(audio.Location), "a", dir)
+		}
+		if err != nil {
+			discord.Errorf("error segmenting audio %s: %v", audio.Location, err)
+		}
+	}
+}
+
+// packageHls segments every encoded codec, then writes the master playlist
+func (job *Job) packageHls(ctx context.Context) error {
+	if job.Duration == 0 {
+		err := job.updateDuration(ctx, job.GetCodecVideo(job.EncodedCodecs[0]))
+		if err != nil {
+			return err
+		}
+	}
+	err := os.MkdirAll(job.OutputJoin(HlsDir), 0755)
+	if err != nil {
+		return err
+	}
+	for _, codec := range job.EncodedCodecs {
+		err = os.RemoveAll(job.OutputJoin(HlsDir, codec))
+		if err != nil {
+			return err
+		}
+		err = job.segment(ctx, job.GetCodecVideo(codec), "v", codec)
+		if err != nil {
+			discord.Errorf("error segmenting video %s: %v", codec, err)
+		}
+	}
+	return job.writeHlsMaster(ctx)
+}
+
+// writeHlsMaster writes a master playlist that references the segmented codecs alongside the audio renditions
+// segmentAudio packaged and the converted WebVTT subtitles. It only reads what is already packaged, so it also
+// refreshes the playlist of a completed job whose encoded files are gone.
+func (job *Job) writeHlsMaster(ctx context.Context) error {
+	var media []string
+	audioGroup := ""
+	defaultSet := false
+	for _, audio := range job.Streams {
+		if audio.CodecType != AudioType {
+			continue
+		}
+		dir := "audio-" + audio.Id()
+		if _, err := os.Stat(job.OutputJoin(HlsDir, dir, hlsMedia)); err != nil {
+			continue
+		}
+		media = append(media, fmt.Sprintf(
+			`#EXT-X-MEDIA:TYPE=AUDIO,GROUP-ID="%s",NAME="%s",LANGUAGE="%s",DEFAULT=%s,AUTOSELECT=YES,CHANNELS="%d",URI="%s/%s"`,
+			hlsAudioGroup, hlsName(audio), audio.Language, hlsBool(!defaultSet), audio.Channels, dir, hlsMedia))
+		audioGroup = hlsAudioGroup
+		defaultSet = true
+	}
+
+	subtitleGroup := ""
+	timestampMap := ""
+	for _, subtitle := range job.Streams {
+		if subtitle.CodecType != SubtitlesType || subtitle.CodecName != "webvtt" {
+			continue
+		}
+		if timestampMap == "" {
+			timestampMap = job.hlsTimestampMap(ctx)
+		}
+		err := job.mapTimestamps(subtitle.Location, timestampMap)
+		if err != nil {
+			discord.Errorf("error mapping timestamps of %s: %v", subtitle.Location, err)
+			continue
+		}
+		playlist := fmt.Sprintf("sub-%s.m3u8", subtitle.Id())
+		content := fmt.Sprintf("#EXTM3U\n#EXT-X-VERSION:3\n#EXT-X-TARGETDURATION:%d\n#EXT-X-PLAYLIST-TYPE:VOD\n#EXT-X-MEDIA-SEQUENCE:0\n#EXTINF:%.3f,\n../%s\n#EXT-X-ENDLIST\n",
+			int(math.Ceil(job.Duration)), job.Duration, subtitle.Location)
+		err = os.WriteFile(job.OutputJoin(HlsDir, playlist), []byte(content), 0644)
+		if err != nil {
+			discord.Errorf("error writing subtitle playlist %s: %v", playlist, err)
+			continue
+		}
+		media = append(media, fmt.Sprintf(
+			`#EXT-X-MEDIA:TYPE=SUBTITLES,GROUP-ID="%s",NAME="%s",LANGUAGE="%s",DEFAULT=NO,AUTOSELECT=YES,URI="%s"`,
+			hlsSubtitleGrp, hlsName(subtitle), subtitle.Language, playlist))
+		subtitleGroup = hlsSubtitleGrp
+	}
+
+	var variants []string
+	for _, codec := range job.EncodedCodecs {
+		if _, err := os.Stat(job.OutputJoin(HlsDir, codec, hlsMedia)); err != nil {
+			continue
+		}
+		rendition := job.GetRendition(codec)
+		if rendition == nil {
+			rendition = &Rendition{Name: codec, Codec: codec, Width: job.Width, Height: job.Height}
+		}
+		bandwidth := job.bandwidth(job.OutputJoin(HlsDir, codec))
+		if rendition.Bitrate > bandwidth {
+			bandwidth = rendition.Bitrate
+		}
+		attributes := []string{fmt.Sprintf("BANDWIDTH=%d", bandwidth)}
+		if c, ok := hlsCodecs[rendition.Codec]; ok {
+			if audioGroup != "" {
+				c += ",opus"
+			}
+			attributes = append(attributes, fmt.Sprintf(`CODECS="%s"`, c))
+		}
+		if rendition.Width > 0 && rendition.Height > 0 {
+			attributes = append(attributes, fmt.Sprintf("RESOLUTION=%dx%d", rendition.Width, rendition.Height))
+		}
+		if r, ok := hlsVideoRanges[rendition.DynamicRange]; ok {
+			attributes = append(attributes, "VIDEO-RANGE="+r)
+		}
+		if audioGroup != "" {
+			attributes = append(attributes, fmt.Sprintf(`AUDIO="%s"`, audioGroup))
+		}
+		if subtitleGroup != "" {
+			attributes = append(attributes, fmt.Sprintf(`SUBTITLES="%s"`, subtitleGroup))
+		}
+		variants = append(variants, fmt.Sprintf("#EXT-X-STREAM-INF:%s\n%s/%s", strings.Join(attributes, ","), codec, hlsMedia))
+	}
+	if len(variants) == 0 {
+		return fmt.Errorf("no variants packaged for %s", job.Input)
+	}
+
+	master := "#EXTM3U\n#EXT-X-VERSION:7\n#EXT-X-INDEPENDENT-SEGMENTS\n"
+	if len(media) > 0 {
+		master += strings.Join(media, "\n") + "\n"
+	}
+	master += strings.Join(variants, "\n") + "\n"
+	err := os.WriteFile(job.OutputJoin(HlsDir, HlsMaster), []byte(master), 0644)
+	if err != nil {
+		return err
+	}
+	job.Playlist = HlsDir + "/" + HlsMaster
+	discord.Infof("HLS playlist generated: %s", job.OutputJoin(job.Playlist))
+	return nil
+}
+
+// hlsTimestampMap returns the X-TIMESTAMP-MAP header tying the cue times of a WebVTT rendition to the video
+// segments, the cue time zero is the first video frame as it is for players reading the file directly
+func (job *Job) hlsTimestampMap(ctx context.Context) string {
+	start := 0.0
+	for _, codec := range job.EncodedCodecs {
+		playlist := job.OutputJoin(HlsDir, codec, hlsMedia)
+		if _, err := os.Stat(playlist); err != nil {
+			continue
+		}
+		out, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error",
+			"-show_entries", "format=start_time", "-of", "default=noprint_wrappers=1:nokey=1", playlist))
+		if err != nil {
+			discord.Errorf("error probing the start of %s: %v", playlist, err)
+		} else if s, err := strconv.ParseFloat(strings.TrimSpace(string(out)), 64); err == nil {
+			start = s
+		}
+		break
+	}
+	return fmt.Sprintf("X-TIMESTAMP-MAP=MPEGTS:%d,LOCAL:00:00:00.000", int64(math.Round(start*hlsTimescale)))
+}
+
+// mapTimestamps puts timestampMap in the header of the WebVTT file at location, replacing an earlier one
+func (job *Job) mapTimestamps(location, timestampMap string) error {
+	content, err := os.ReadFile(job.OutputJoin(location))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(job.OutputJoin(location), []byte(withTimestampMap(string(content), timestampMap)), 0644)
+}
+
+func withTimestampMap(vtt, timestampMap string) string {
+	header, body, _ := strings.Cut(vtt, "\n\n")
+	lines := strings.Split(header, "\n")
+	kept := []string{lines[0], timestampMap}
+	for _, line := range lines[1:] {
+		if !strings.HasPrefix(strings.TrimSpace(line), "X-TIMESTAMP-MAP=") {
+			kept = append(kept, line)
+		}
+	}
+	return strings.Join(kept, "\n") + "\n\n" + body
+}
+
+func hlsName(stream Stream) string {
+	name := stream.Language
+	if stream.Title != "" {
+		name = stream.Title
+	}
+	if name == "" {
+		name = fmt.Sprintf("%s %d", stream.CodecType, stream.Index)
+	}
+	return strings.ReplaceAll(name, `"`, "'")
+}
+
+func hlsBool(b bool) string {
+	if b {
+		return "YES"
+	}
+	return "NO"
+}
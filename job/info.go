@@ -4,12 +4,16 @@ import (
 	"Sparkle/config"
 	"Sparkle/utils"
 	"fmt"
+	"path/filepath"
 	"strings"
+	"sync"
+	"time"
 )
 
 const (
 	Complete         = "complete"
 	Incomplete       = "incomplete"
+	Interrupted      = "interrupted"
 	StreamsExtracted = "streams_extracted"
 	JobFile          = "job.json"
 	ThumbnailVtt     = "storyboard.vtt"
@@ -26,7 +30,14 @@ type StreamInfo struct {
 	CodecType string `json:"codec_type"`
 	CodecName string `json:"codec_name"`
 	Channels  int    `json:"channels,omitempty"` // Ensure this matches the JSON structure
-	Tags      struct {
+	// colour metadata of video streams, used to tell HDR from SDR
+	ColorTransfer  string `json:"color_transfer,omitempty"`
+	ColorPrimaries string `json:"color_primaries,omitempty"`
+	ColorSpace     string `json:"color_space,omitempty"`
+	SideDataList   []struct {
+		SideDataType string `json:"side_data_type"`
+	} `json:"side_data_list,omitempty"`
+	Tags struct {
 		Language string `json:"language"`
 		Title    string `json:"title"`
 		Filename string `json:"filename"`
@@ -51,17 +62,27 @@ type JobStripped struct {
 	Input          string
 	State          string
 	EncodedCodecs  []string
+	Renditions     []Rendition `json:",omitempty"`
 	MappedAudio    map[string][]StreamStripped
 	Streams        []StreamStripped `json:",omitempty"`
 	Duration       float64
 	Chapters       []ChapterStripped `json:",omitempty"`
 	DominantColors []string          `json:",omitempty"`
 	Files          map[string]int64
-	OriSize        int64 `json:",omitempty"`
-	OriModTime     int64 `json:",omitempty"`
-	JobModTime     int64 `json:",omitempty"`
-	Fast           bool  `json:",omitempty"`
-	Translate      bool  `json:",omitempty"`
+	OriSize        int64  `json:",omitempty"`
+	OriModTime     int64  `json:",omitempty"`
+	JobModTime     int64  `json:",omitempty"`
+	Fast           bool   `json:",omitempty"`
+	Translate      bool   `json:",omitempty"`
+	Playlist       string `json:",omitempty"`
+	DynamicRange   string `json:",omitempty"`
+	// TranslationDeferredUntil is the unix time a translation skipped for AI quota can be retried
+	TranslationDeferredUntil int64 `json:",omitempty"`
+}
+
+// TranslationDue reports whether the job was completed without its translation and the AI quota is back
+func (job *JobStripped) TranslationDue() bool {
+	return job.TranslationDeferredUntil != 0 && time.Now().Unix() >= job.TranslationDeferredUntil
 }
 
 type StreamStripped struct {
@@ -70,6 +91,10 @@ type StreamStripped struct {
 	Location  string `json:",omitempty"`
 	Language  string `json:",omitempty"`
 	Title     string `json:",omitempty"`
+	Variant   string `json:",omitempty"`
+	// measured loudness of the source track in LUFS and dBTP
+	IntegratedLoudness float64 `json:",omitempty"`
+	TruePeak           float64 `json:",omitempty"`
 }
 
 type ChapterStripped struct {
@@ -80,11 +105,13 @@ type ChapterStripped struct {
 
 type Job struct {
 	Id             string
+	InputRoot      string `json:",omitempty"` // input directory the job was picked up from, defaults to config.TheConfig.Input
 	InputParent    string
 	Input          string
 	State          string
 	SHA256         string
 	EncodedCodecs  []string
+	Renditions     []Rendition
 	MappedAudio    map[string][]Stream
 	Streams        []Stream
 	Duration       float64
@@ -97,6 +124,26 @@ type Job struct {
 	OriModTime     int64
 	Fast           bool
 	Translate      bool
+	Playlist       string
+	DynamicRange   string
+	Steps          []string
+	// TranslationDeferredUntil is set while the translation waits for the AI quota, see ai.ExhaustedError
+	TranslationDeferredUntil int64 `json:",omitempty"`
+	mutex                    sync.Mutex
+	progress                 *progressTracker
+}
+
+// Rendition is one encoded output of the bitrate ladder, Name is what gets recorded in EncodedCodecs
+type Rendition struct {
+	Name      string
+	Codec     string
+	MaxHeight int    `json:",omitempty"`
+	Quality   string `json:",omitempty"`
+	Width     int
+	Height    int
+	Bitrate   int64
+	// DynamicRange is one of RangeSdr, RangeHdr10, RangeHlg or RangeDolbyVision
+	DynamicRange string `json:",omitempty"`
 }
 
 type Stream struct {
@@ -111,6 +158,20 @@ type Stream struct {
 	MimeType   string
 	Channels   int
 	SampleRate int
+	Variant    string `json:",omitempty"` // e.g. NightVariant for processed copies of an audio track
+	// measured loudness of the source track in LUFS, dBTP and LU
+	IntegratedLoudness float64 `json:",omitempty"`
+	TruePeak           float64 `json:",omitempty"`
+	LoudnessRange      float64 `json:",omitempty"`
+}
+
+// Id identifies a stream in file names, "<index>-<language>" with the variant appended
+func (s Stream) Id() string {
+	id := fmt.Sprintf("%d-%s", s.Index, s.Language)
+	if s.Variant != "" {
+		id += "-" + s.Variant
+	}
+	return id
 }
 
 type Chapter struct {
@@ -138,9 +199,24 @@ func (job *Job) OutputJoin(args ...string) string {
 }
 
 func (job *Job) InputJoin(args ...string) string {
+	if job.InputRoot != "" {
+		return filepath.Join(append([]string{job.InputRoot, job.InputParent}, args...)...)
+	}
 	return utils.InputJoin(append([]string{job.InputParent}, args...)...)
 }
 
+func (job *Job) addStream(s Stream) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.Streams = append(job.Streams, s)
+}
+
+func (job *Job) addEncodedCodec(codec string) {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.EncodedCodecs = append(job.EncodedCodecs, codec)
+}
+
 func (job *Job) GetCodecVideo(codec string) string {
 	return job.OutputJoin(fmt.Sprintf("%s.%s", codec, config.TheConfig.VideoExt))
 }
@@ -0,0 +1,120 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// rung is a single step of the bitrate ladder, an empty MaxHeight keeps the source resolution
+type rung struct {
+	MaxHeight int
+	Quality   string
+}
+
+// name returns the output name of an encoder at this rung, the top rung keeps the bare encoder name
+func (r rung) name(encoder string, top bool) string {
+	if top || r.MaxHeight == 0 {
+		return encoder
+	}
+	return fmt.Sprintf("%s-%dp", encoder, r.MaxHeight)
+}
+
+// parseLadder parses entries like "1080:21" (max height:quality) sorted from the highest rung,
+// rungs above the source height are dropped but the ladder never ends up empty.
+func parseLadder(entries []string, sourceHeight int) ([]rung, error) {
+	var rungs []rung
+	for _, entry := range utils.RemoveEmptyStrings(entries) {
+		s := strings.Split(strings.TrimSpace(entry), ":")
+		height, err := strconv.Atoi(strings.TrimSuffix(s[0], "p"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid ladder rung %s: %v", entry, err)
+		}
+		quality := config.TheConfig.ConstantQuality
+		if len(s) > 1 && s[1] != "" {
+			quality = s[1]
+		}
+		rungs = append(rungs, rung{MaxHeight: height, Quality: quality})
+	}
+	if len(rungs) == 0 {
+		return []rung{{Quality: config.TheConfig.ConstantQuality}}, nil
+	}
+	sort.Slice(rungs, func(i, j int) bool {
+		return rungs[i].MaxHeight > rungs[j].MaxHeight
+	})
+	fitting := make([]rung, 0)
+	for _, r := range rungs {
+		if sourceHeight == 0 || r.MaxHeight <= sourceHeight {
+			fitting = append(fitting, r)
+		}
+	}
+	if len(fitting) == 0 {
+		// source is smaller than every rung, encode it once at its own resolution
+		return []rung{{Quality: rungs[len(rungs)-1].Quality}}, nil
+	}
+	if len(fitting) < len(rungs) && fitting[0].MaxHeight < sourceHeight {
+		// rungs were dropped, keep one rendition at source resolution with the quality of the nearest dropped rung
+		fitting = append([]rung{{Quality: rungs[len(rungs)-len(fitting)-1].Quality}}, fitting...)
+	}
+	return fitting, nil
+}
+
+// sourceHeight returns the height of the first video stream of the input
+func (job *Job) sourceHeight(ctx context.Context) int {
+	out, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=height", "-of", "default=noprint_wrappers=1:nokey=1", job.InputJoin(job.Input)))
+	if err != nil {
+		discord.Errorf("Error getting source height: %v", err)
+		return 0
+	}
+	height, _ := strconv.Atoi(strings.TrimSpace(string(out)))
+	return height
+}
+
+type renditionProbe struct {
+	Streams []struct {
+		Width  int `json:"width"`
+		Height int `json:"height"`
+	} `json:"streams"`
+	Format struct {
+		BitRate string `json:"bit_rate"`
+	} `json:"format"`
+}
+
+// probeRendition fills in the actual width, height and bitrate of an encoded rendition
+func (job *Job) probeRendition(ctx context.Context, r *Rendition) error {
+	out, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error", "-select_streams", "v:0",
+		"-show_entries", "stream=width,height:format=bit_rate", "-of", "json", job.GetCodecVideo(r.Name)))
+	if err != nil {
+		return err
+	}
+	var probeOutput renditionProbe
+	err = json.Unmarshal(out, &probeOutput)
+	if err != nil {
+		return err
+	}
+	if len(probeOutput.Streams) > 0 {
+		r.Width = probeOutput.Streams[0].Width
+		r.Height = probeOutput.Streams[0].Height
+	}
+	r.Bitrate, _ = strconv.ParseInt(probeOutput.Format.BitRate, 10, 64)
+	discord.Infof("Rendition %s: %dx%d, %d bps", r.Name, r.Width, r.Height, r.Bitrate)
+	return nil
+}
+
+// GetRendition returns the rendition recorded under name
+func (job *Job) GetRendition(name string) *Rendition {
+	for i := range job.Renditions {
+		if job.Renditions[i].Name == name {
+			return &job.Renditions[i]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+const NightVariant = "night"
+
+// nightFilter compresses the dynamic range and lifts the speech band so dialogue stays audible at low volume
+const nightFilter = "highpass=f=80,equalizer=f=1500:t=q:w=1.2:g=5," +
+	"acompressor=threshold=-28dB:ratio=6:attack=10:release=200:makeup=6,alimiter=limit=0.9"
+
+// loudnormStats is what the loudnorm filter prints with print_format=json, ffmpeg reports numbers as strings
+type loudnormStats struct {
+	InputI       string `json:"input_i"`
+	InputTp      string `json:"input_tp"`
+	InputLra     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+func loudnormTarget() string {
+	return fmt.Sprintf("I=%g:TP=%g:LRA=%g", config.TheConfig.LoudnormIntegrated, config.TheConfig.LoudnormTruePeak, config.TheConfig.LoudnormRange)
+}
+
+// measureLoudness runs the first loudnorm pass over an audio file
+func measureLoudness(ctx context.Context, file string) (*loudnormStats, error) {
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-hide_banner", "-nostats", "-i", file,
+		"-af", "loudnorm="+loudnormTarget()+":print_format=json", "-f", "null", "-")
+	out, err := utils.RunCommand(cmd)
+	if err != nil {
+		return nil, err
+	}
+	start := strings.LastIndex(string(out), "{")
+	end := strings.LastIndex(string(out), "}")
+	if start < 0 || end < start {
+		return nil, fmt.Errorf("no loudnorm stats in output of %s", file)
+	}
+	stats := &loudnormStats{}
+	err = json.Unmarshal(out[start:end+1], stats)
+	if err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// encodeAudio writes file through the audio filter to dest using the same codec settings as the encoders
+func encodeAudio(ctx context.Context, file, dest, filter string) error {
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-y", "-i", file, "-af", filter,
+		"-c:a", "libopus", "-b:a", "160k", "-ar", "48000", dest)
+	discord.Infof("Command: %s", cmd.String())
+	_, err := utils.RunCommand(cmd)
+	return err
+}
+
+// normalizeAudio applies two-pass EBU R128 normalization to every extracted audio track and
+// optionally adds a night mode variant of each
+func (job *Job) normalizeAudio(ctx context.Context) error {
+	if !config.TheConfig.EnableLoudnorm && !config.TheConfig.EnableNightMode {
+		return nil
+	}
+	var night []Stream
+	for i := range job.Streams {
+		audio := &job.Streams[i]
+		if audio.CodecType != AudioType || audio.Variant != "" {
+			continue
+		}
+		file := job.OutputJoin(audio.Location)
+		stats, err := measureLoudness(ctx, file)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			discord.Errorf("error measuring loudness of %s: %v", audio.Location, err)
+			continue
+		}
+		audio.IntegratedLoudness, _ = strconv.ParseFloat(stats.InputI, 64)
+		audio.TruePeak, _ = strconv.ParseFloat(stats.InputTp, 64)
+		audio.LoudnessRange, _ = strconv.ParseFloat(stats.InputLra, 64)
+		discord.Infof("Loudness of %s: %s LUFS, %s dBTP, %s LU", audio.Location, stats.InputI, stats.InputTp, stats.InputLra)
+
+		if config.TheConfig.EnableLoudnorm {
+			filter := fmt.Sprintf("loudnorm=%s:measured_I=%s:measured_TP=%s:measured_LRA=%s:measured_thresh=%s:offset=%s:linear=true",
+				loudnormTarget(), stats.InputI, stats.InputTp, stats.InputLra, stats.InputThresh, stats.TargetOffset)
+			tmp := job.OutputJoin("normalized-" + audio.Location)
+			err = encodeAudio(ctx, file, tmp, filter)
+			if err == nil {
+				err = os.Rename(tmp, file)
+			}
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				discord.Errorf("error normalizing %s: %v", audio.Location, err)
+			}
+		}
+
+		if config.TheConfig.EnableNightMode {
+			s := *audio
+			s.Variant = NightVariant
+			s.Location = fmt.Sprintf("%s.%s", s.Id(), s.CodecName)
+			s.Title = strings.TrimSpace(hlsName(*audio) + " (Night)")
+			err = encodeAudio(ctx, file, job.OutputJoin(s.Location), nightFilter)
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				discord.Errorf("error creating night mode track for %s: %v", audio.Location, err)
+				continue
+			}
+			night = append(night, s)
+		}
+	}
+	for _, s := range night {
+		job.addStream(s)
+	}
+	return nil
+}
@@ -1,11 +1,14 @@
 package job
 
 import (
+	"Sparkle/ai"
 	"Sparkle/config"
 	"Sparkle/discord"
 	"Sparkle/translation"
 	"Sparkle/utils"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/cenkalti/dominantcolor"
 	log "github.com/sirupsen/logrus"
@@ -13,13 +16,14 @@ import (
 	"math"
 	"os"
 	"os/exec"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 )
 
-func (job *Job) extractChapters() error {
-	cmd := exec.Command(config.TheConfig.Ffprobe, "-v", "quiet", "-print_format", "json", "-show_chapters", job.InputJoin(job.Input))
+func (job *Job) extractChapters(ctx context.Context) error {
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "quiet", "-print_format", "json", "-show_chapters", job.InputJoin(job.Input))
 	out, err := utils.RunCommand(cmd)
 	if err != nil {
 		return err
@@ -34,9 +38,12 @@ func (job *Job) extractChapters() error {
 	return nil
 }
 
-func ContainsTranslatableSubtitles(path string) (bool, error) {
+func ContainsTranslatableSubtitles(ctx context.Context, path string) (bool, error) {
+	if len(sourceSidecars(path)) > 0 {
+		return true, nil
+	}
 	// Run ffprobe command to get subtitle codec names
-	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "s", "-show_entries", "stream=codec_name", "-of", "csv=p=0", path)
+	cmd := exec.CommandContext(ctx, "ffprobe", "-v", "error", "-select_streams", "s", "-show_entries", "stream=codec_name", "-of", "csv=p=0", path)
 	output, err := utils.RunCommand(cmd)
 	if err != nil {
 		return false, fmt.Errorf("ffprobe error: %v", err)
@@ -53,8 +60,8 @@ func ContainsTranslatableSubtitles(path string) (bool, error) {
 	return false, nil
 }
 
-func (job *Job) ExtractStreams(path, t string) error {
-	cmd := exec.Command(config.TheConfig.Ffprobe, "-v", "quiet", "-print_format", "json", "-show_streams", path)
+func (job *Job) ExtractStreams(ctx context.Context, path, t string) error {
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "quiet", "-print_format", "json", "-show_streams", path)
 	out, err := utils.RunCommand(cmd)
 	if err != nil {
 		return err
@@ -64,6 +71,9 @@ func (job *Job) ExtractStreams(path, t string) error {
 	if err != nil {
 		return err
 	}
+	job.Streams = slices.DeleteFunc(job.Streams, func(s Stream) bool {
+		return s.CodecType == t
+	})
 	meaningful := false
 	for _, stream := range probeOutput.Streams {
 		if stream.CodecType == t {
@@ -86,17 +96,17 @@ func (job *Job) ExtractStreams(path, t string) error {
 					Channels:  stream.Channels,
 				}
 				if stream.CodecType == AttachmentType {
-					cmd = exec.Command(config.TheConfig.Ffmpeg, "-y", fmt.Sprintf("-dump_attachment:%d", stream.Index), job.OutputJoin(filename), "-i", path, "-t", "0", "-f", "null", "null")
+					cmd = exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-y", fmt.Sprintf("-dump_attachment:%d", stream.Index), job.OutputJoin(filename), "-i", path, "-t", "0", "-f", "null", "null")
 				} else if cs == "webvttFromASS" {
 					err = translation.AssToVTT(job.OutputJoin(fmt.Sprintf("%s.ass", id)))
 				} else {
-					cmd = exec.Command(config.TheConfig.Ffmpeg, "-y", "-i", path, "-c:s", cs, "-map", fmt.Sprintf("0:%d", stream.Index), job.OutputJoin(filename))
+					cmd = exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-y", "-i", path, "-c:s", cs, "-map", fmt.Sprintf("0:%d", stream.Index), job.OutputJoin(filename))
 				}
 				if cmd != nil {
 					_, err = utils.RunCommand(cmd)
 				}
 				if err == nil {
-					job.Streams = append(job.Streams, s)
+					job.addStream(s)
 				} else {
 					discord.Errorf("error converting %s: %v", t, err)
 				}
@@ -129,13 +139,17 @@ func (job *Job) ExtractStreams(path, t string) error {
 			}
 		}
 	}
+	if t == SubtitlesType && path == job.InputJoin(job.Input) {
+		sidecars := job.extractSidecars(ctx)
+		meaningful = meaningful || sidecars > 0
+	}
 	if !meaningful && (t == AudioType || t == SubtitlesType) {
 		return fmt.Errorf("no %s streams found in %s", t, path)
 	}
 	return nil
 }
 
-func (job *Job) ffmpegCopyOnly() error {
+func (job *Job) ffmpegCopyOnly(ctx context.Context) error {
 	outputFile := job.OutputJoin(fmt.Sprintf("hevc.%s", config.TheConfig.VideoExt))
 	discord.Infof("Converting video: %s -> %s", job.Input, outputFile)
 	args := []string{
@@ -146,80 +160,187 @@ func (job *Job) ffmpegCopyOnly() error {
 		"-c:a", "libopus",
 		"-ac", "2",
 		"-map", "-0:s",
+		"-progress", "pipe:1",
+		"-nostats",
 		outputFile,
 	}
-	cmd := exec.Command(
+	cmd := exec.CommandContext(ctx,
 		config.TheConfig.Ffmpeg, args...)
-	_, err := utils.RunCommand(cmd)
+	_, err := utils.RunCommandWithProgress(cmd,
+		job.ffmpegProgress("hevc", StageEncode, mediaDuration(ctx, job.InputJoin(job.Input))))
 	if err == nil {
-		job.EncodedCodecs = append(job.EncodedCodecs, "hevc")
+		job.addEncodedCodec("hevc")
+		job.detectDynamicRange(ctx)
+		job.recordRenditions(ctx, []Rendition{{Name: "hevc", Codec: "hevc", DynamicRange: job.DynamicRange}})
 	}
 	return err
 }
 
-func (job *Job) handbrakeTranscode() error {
+// fastSdrCopy tone-maps an HDR source once at its own resolution, the fast path has no ladder to encode.
+// It is a full encode, so it runs as its own step in an encode slot rather than with the copy.
+func (job *Job) fastSdrCopy(ctx context.Context) error {
+	if job.DynamicRange == RangeSdr || !config.TheConfig.EnableSdrTonemap {
+		return nil
+	}
+	if !canTonemap() {
+		discord.Errorf("ffmpeg has no zscale filter (libzimg), skipping the SDR copy of %s", job.Input)
+		return nil
+	}
+	rendition := Rendition{Name: "hevc" + sdrSuffix, Codec: "hevc", Quality: config.TheConfig.ConstantQuality,
+		DynamicRange: RangeSdr}
+	target := encodeTarget{
+		Rendition: rendition,
+		Encoder:   config.TheConfig.HevcEncoder,
+		Preset:    config.TheConfig.HevcPreset,
+		Input:     job.InputJoin(job.Input),
+		Output:    job.GetCodecVideo(rendition.Name),
+		Duration:  mediaDuration(ctx, job.InputJoin(job.Input)),
+		Tonemap:   true,
+	}
+	backend := encoderBackends[FfmpegBackend]
+	cmd := backend.Command(ctx, target)
+	discord.Infof("Command: %s", cmd.String())
+	_, err := utils.RunCommandWithProgress(cmd, backend.Progress(job, target))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		discord.Errorf("error tone-mapping %s: %v", job.Input, err)
+		return nil
+	}
+	job.addEncodedCodec(rendition.Name)
+	job.recordRenditions(ctx, append(slices.Clone(job.Renditions), rendition))
+	return nil
+}
+
+// transcode encodes every configured encoder at every rung of the ladder with the encoder's backend
+func (job *Job) transcode(ctx context.Context) error {
 	encoders := strings.Split(config.TheConfig.Encoder, ",")
+	rungs, err := parseLadder(config.TheConfig.Ladder, job.sourceHeight(ctx))
+	if err != nil {
+		return err
+	}
+	job.detectDynamicRange(ctx)
+	// HDR sources get a tone-mapped SDR copy of every rung for displays that can't show HDR
+	ranges := []string{job.DynamicRange}
+	if job.DynamicRange != RangeSdr && config.TheConfig.EnableSdrTonemap {
+		if canTonemap() {
+			ranges = append(ranges, RangeSdr)
+		} else {
+			discord.Errorf("ffmpeg has no zscale filter (libzimg), skipping the SDR copy of %s", job.Input)
+		}
+	}
 	wg := sync.WaitGroup{}
 	job.EncodedExt = config.TheConfig.VideoExt
-	runEncoder := func(encoder, encoderCmd, encoderPreset, encoderProfile, encoderTune string) {
-		outputFile := job.OutputJoin(fmt.Sprintf("%s.%s", encoder, config.TheConfig.VideoExt))
-		discord.Infof("Converting video: %s -> %s", job.Input, outputFile)
-		args := []string{
-			"-i", job.InputJoin(job.Input),
-			"-o", outputFile,
-			"--encoder", encoderCmd,
-			"--vfr",
-			"--quality", config.TheConfig.ConstantQuality,
-			"--encoder-preset", encoderPreset,
-			"--subtitle", "none",
-			"--aencoder", "opus",
-			"--audio-lang-list", "any",
-			"--all-audio",
-			"--optimize", // web optimized
-			"--mixdown", "stereo"}
-		if encoderProfile != "" {
-			args = append(args, "--encoder-profile", encoderProfile)
-		}
-		if encoderTune != "" {
-			args = append(args, "--encoder-tune", encoderTune)
+	duration := mediaDuration(ctx, job.InputJoin(job.Input))
+	var planned []Rendition
+	runEncoder := func(encoder, encoderCmd, encoderPreset, encoderProfile, encoderTune string) error {
+		configured, err := backendFor(encoder)
+		if err != nil {
+			return err
 		}
-		cmd := exec.Command(
-			config.TheConfig.HandbrakeCli, args...)
-		log.Infof("Command: %s", cmd.String())
-		wg.Add(1)
-		go func() {
-			_, err := utils.RunCommand(cmd)
-			if err == nil {
-				job.EncodedCodecs = append(job.EncodedCodecs, encoder)
+		for _, dynamicRange := range ranges {
+			for i, r := range rungs {
+				name := r.name(encoder, i == 0)
+				tonemap := dynamicRange != job.DynamicRange
+				backend := configured
+				if tonemap {
+					// HandBrakeCLI has no tone-mapping filter
+					name += sdrSuffix
+					backend = encoderBackends[FfmpegBackend]
+				}
+				rendition := Rendition{Name: name, Codec: encoder, MaxHeight: r.MaxHeight, Quality: r.Quality, DynamicRange: dynamicRange}
+				planned = append(planned, rendition)
+				outputFile := job.GetCodecVideo(name)
+				if _, err := os.Stat(outputFile); err == nil && job.StepDone(encodeStep(name)) {
+					discord.Infof("Skipping completed step: %s", encodeStep(name))
+					continue
+				}
+				discord.Infof("Converting video: %s -> %s", job.Input, outputFile)
+				target := encodeTarget{
+					Rendition: rendition,
+					Encoder:   encoderCmd,
+					Preset:    encoderPreset,
+					Profile:   encoderProfile,
+					Tune:      encoderTune,
+					Input:     job.InputJoin(job.Input),
+					Output:    outputFile,
+					Duration:  duration,
+					Tonemap:   tonemap,
+				}
+				cmd := backend.Command(ctx, target)
+				log.Infof("Command: %s", cmd.String())
+				wg.Add(1)
+				go func() {
+					err := limited(ctx, &encodeSlots, func() error {
+						_, err := utils.RunCommandWithProgress(cmd, backend.Progress(job, target))
+						return err
+					})()
+					if err == nil {
+						job.addEncodedCodec(name)
+						err = job.markStep(encodeStep(name))
+						if err != nil {
+							discord.Errorf("error recording step: %v", err)
+						}
+					}
+					wg.Done()
+				}()
 			}
-			wg.Done()
-		}()
+		}
+		return nil
 	}
 	for _, encoder := range encoders {
 		switch encoder {
 		case "av1":
-			runEncoder(encoder, config.TheConfig.Av1Encoder, config.TheConfig.Av1Preset, "", "")
+			err = runEncoder(encoder, config.TheConfig.Av1Encoder, config.TheConfig.Av1Preset, "", "")
 		case "hevc":
-			runEncoder(encoder, config.TheConfig.HevcEncoder, config.TheConfig.HevcPreset, "", "")
+			err = runEncoder(encoder, config.TheConfig.HevcEncoder, config.TheConfig.HevcPreset, "", "")
 		case "h264-10bit":
-			runEncoder(encoder, config.TheConfig.H26410BitEncoder, config.TheConfig.H26410BitPreset, "", "")
+			err = runEncoder(encoder, config.TheConfig.H26410BitEncoder, config.TheConfig.H26410BitPreset, "", "")
 		case "h264-8bit":
-			runEncoder(encoder, config.TheConfig.H2648BitEncoder, config.TheConfig.H2648BitPreset, config.TheConfig.H2648BitProfile, config.TheConfig.H2648BitTune)
+			err = runEncoder(encoder, config.TheConfig.H2648BitEncoder, config.TheConfig.H2648BitPreset, config.TheConfig.H2648BitProfile, config.TheConfig.H2648BitTune)
 		default:
-			return fmt.Errorf("unsupported encoder: %s", encoder)
+			err = fmt.Errorf("unsupported encoder: %s", encoder)
+		}
+		if err != nil {
+			wg.Wait()
+			return err
 		}
 	}
 	wg.Wait()
+	job.recordRenditions(ctx, planned)
 	return nil
 }
 
-func (job *Job) translateFlow() error {
+// recordRenditions keeps the successfully encoded renditions in ladder order, so the first encoded codec
+// is always the highest quality one.
+func (job *Job) recordRenditions(ctx context.Context, planned []Rendition) {
+	encoded := make([]string, 0, len(job.EncodedCodecs))
+	renditions := make([]Rendition, 0, len(planned))
+	for _, r := range planned {
+		if !slices.Contains(job.EncodedCodecs, r.Name) {
+			continue
+		}
+		encoded = append(encoded, r.Name)
+		err := job.probeRendition(ctx, &r)
+		if err != nil {
+			discord.Errorf("error probing rendition %s: %v", r.Name, err)
+		}
+		renditions = append(renditions, r)
+	}
+	job.mutex.Lock()
+	job.Renditions = renditions
+	job.EncodedCodecs = encoded
+	job.mutex.Unlock()
+}
+
+func (job *Job) translateFlow(ctx context.Context) error {
 	if len(config.TheConfig.TranslationLanguages) == 0 || !job.Translate {
 		return nil
 	}
 
 	source := job.InputJoin(job.Input)
-	translatable, err := ContainsTranslatableSubtitles(source)
+	translatable, err := ContainsTranslatableSubtitles(ctx, source)
 	if err != nil {
 		return err
 	}
@@ -232,25 +353,82 @@ func (job *Job) translateFlow() error {
 			languageCode := strings.Split(languageWithCode, ";")[1]
 			dest := job.OutputJoin(fmt.Sprintf("%s.%s", languageCode, subtitleType))
 
-			err := translation.Translate(job.Input, job.OutputJoin(), source, dest, languageWithCode, subtitleType, true)
+			err := translation.Translate(ctx, job.Input, job.OutputJoin(), source, dest, languageWithCode, subtitleType, true)
 			if err != nil {
 				discord.Errorf("Error translating: %v", err)
 				return err
 			}
 
 			discord.Infof("Translated: %s", dest)
+			if config.TheConfig.EnableDualSubtitles {
+				job.addDualStreams(languageWithCode)
+			}
 		}
 	}
+	job.mutex.Lock()
+	job.TranslationDeferredUntil = 0
+	job.mutex.Unlock()
 
 	return nil
 }
 
-func (job *Job) Pipeline() error {
-	var err error
-	job.SHA256, err = utils.CalculateFileSHA256(job.InputJoin(job.Input))
+// addDualStreams registers the bilingual subtitles written for a translation language, the .vtt copy
+// is only there when the .ass one was converted
+func (job *Job) addDualStreams(languageWithCode string) {
+	ss := strings.Split(languageWithCode, ";")
+	language, languageCode := ss[0], ss[1]
+	for _, codec := range []string{"ass", "webvtt", "subrip"} {
+		ext, ok := codecMap[codec]
+		if !ok {
+			ext = codec
+		}
+		location := translation.DualPath(fmt.Sprintf("%s.%s", languageCode, ext))
+		if _, err := os.Stat(job.OutputJoin(location)); err != nil {
+			continue
+		}
+		job.mutex.Lock()
+		job.Streams = slices.DeleteFunc(job.Streams, func(s Stream) bool {
+			return s.Location == location
+		})
+		job.mutex.Unlock()
+		job.addStream(Stream{
+			CodecName: codec,
+			CodecType: SubtitlesType,
+			Language:  languageCode,
+			Title:     language + " + original",
+			Location:  location,
+			Variant:   translation.DualVariant,
+		})
+	}
+}
+
+// Pipeline runs every remaining step of the job, a cancelled context leaves the job marked as interrupted
+// so that the next run resumes it. A completed job interrupted in its deferred translation stays complete.
+func (job *Job) Pipeline(ctx context.Context) error {
+	err := job.pipeline(ctx)
+	if err != nil && ctx.Err() != nil && job.State != Complete {
+		discord.Errorf("Job interrupted: %s, %v", job.Input, err)
+		stateErr := job.updateState(Interrupted)
+		if stateErr != nil {
+			discord.Errorf("error marking job as interrupted: %v", stateErr)
+		}
+	}
+	return err
+}
+
+func (job *Job) pipeline(ctx context.Context) error {
+	sha, err := utils.CalculateFileSHA256(job.InputJoin(job.Input))
 	if err != nil {
 		return err
 	}
+	if job.SHA256 != "" && job.SHA256 != sha {
+		discord.Infof("Input changed since the last run, starting over: %s", job.Input)
+		job.Steps = nil
+	}
+	job.SHA256 = sha
+	if job.State == Complete && len(job.Steps) > 0 {
+		return job.resumeTranslation(ctx)
+	}
 	discord.Infof("Processing Job: %+v", job)
 	err = os.MkdirAll(job.OutputJoin(), 0755)
 	if err != nil {
@@ -261,24 +439,49 @@ func (job *Job) Pipeline() error {
 	if err != nil {
 		return err
 	}
-	err = job.thumbnailsNfo()
+	err = job.runStep(StepThumbnailsNfo, limited(ctx, &extractSlots, func() error {
+		err := job.thumbnailsNfo()
+		if err != nil {
+			return err
+		}
+		job.DominantColors = nil
+		_ = job.extractDominantColor()
+		return nil
+	}))
+	if err != nil {
+		return err
+	}
+	err = job.runStep(StepChapters, limited(ctx, &extractSlots, func() error {
+		return job.extractChapters(ctx)
+	}))
 	if err != nil {
 		return err
 	}
-	_ = job.extractDominantColor()
-	err = job.extractChapters()
+	err = job.runStep(StepSegments, limited(ctx, &extractSlots, func() error {
+		return job.detectSegments(ctx)
+	}))
 	if err != nil {
 		return err
 	}
-	err = job.ExtractStreams(job.InputJoin(job.Input), SubtitlesType)
+	err = job.runStep(StepSubtitles, limited(ctx, &extractSlots, func() error {
+		return job.ExtractStreams(ctx, job.InputJoin(job.Input), SubtitlesType)
+	}))
 	if err != nil {
 		return err
 	}
-	err = job.translateFlow()
+	err = job.runStep(StepTranslation, limited(ctx, &translateSlots, func() error {
+		return job.translateFlow(ctx)
+	}))
+	var exhausted *ai.ExhaustedError
+	if errors.As(err, &exhausted) {
+		err = job.deferTranslation(exhausted.Until)
+	}
 	if err != nil {
 		return err
 	}
-	err = job.ExtractStreams(job.InputJoin(job.Input), AttachmentType)
+	err = job.runStep(StepAttachments, limited(ctx, &extractSlots, func() error {
+		return job.ExtractStreams(ctx, job.InputJoin(job.Input), AttachmentType)
+	}))
 	if err != nil {
 		return err
 	}
@@ -288,22 +491,45 @@ func (job *Job) Pipeline() error {
 	}
 	if config.TheConfig.EnableEncode {
 		if job.Fast {
-			err = job.ffmpegCopyOnly()
+			err = job.runStep(encodeStep("hevc"), limited(ctx, &extractSlots, func() error {
+				return job.ffmpegCopyOnly(ctx)
+			}))
+			if err != nil {
+				return err
+			}
+			err = job.runStep(encodeStep("hevc"+sdrSuffix), limited(ctx, &encodeSlots, func() error {
+				return job.fastSdrCopy(ctx)
+			}))
 			if err != nil {
 				return err
 			}
 		} else {
-			err = job.handbrakeTranscode()
+			err = job.transcode(ctx)
 			if err != nil {
 				return err
 			}
 		}
 		if len(job.EncodedCodecs) > 0 {
-			err = job.ExtractStreams(job.GetCodecVideo(job.EncodedCodecs[0]), AudioType)
+			err = job.runStep(StepAudioMapping, limited(ctx, &extractSlots, func() error {
+				err := job.ExtractStreams(ctx, job.GetCodecVideo(job.EncodedCodecs[0]), AudioType)
+				if err != nil {
+					return err
+				}
+				err = job.normalizeAudio(ctx)
+				if err != nil {
+					return err
+				}
+				// HLS carries the audio renditions itself, muxed copies of the video would only duplicate it
+				if config.TheConfig.EnableHls {
+					job.segmentAudio(ctx)
+				} else {
+					job.mapAudioTracks(ctx)
+				}
+				return nil
+			}))
 			if err != nil {
 				return err
 			}
-			job.mapAudioTracks()
 			for _, audio := range job.Streams {
 				if audio.CodecType == AudioType {
 					err = os.Remove(job.OutputJoin(audio.Location))
@@ -315,10 +541,20 @@ func (job *Job) Pipeline() error {
 		}
 	}
 	if len(job.EncodedCodecs) > 0 {
-		err = job.probe()
+		err = job.runStep(StepSprites, limited(ctx, &extractSlots, func() error {
+			return job.probe(ctx)
+		}))
 		if err != nil {
 			return err
 		}
+		if config.TheConfig.EnableHls {
+			err = job.runStep(StepHls, limited(ctx, &extractSlots, func() error {
+				return job.packageHls(ctx)
+			}))
+			if err != nil {
+				return err
+			}
+		}
 	}
 	err = job.updateState(Complete)
 	if err != nil {
@@ -330,29 +566,54 @@ func (job *Job) Pipeline() error {
 	return nil
 }
 
-func (job *Job) mapAudioTracks() {
-	job.MappedAudio = make(map[string][]Stream)
+// resumeTranslation runs the translation an earlier run deferred, the encoded files of a completed job are
+// gone so only the HLS playlist is refreshed to pick up the new subtitles and the job stays complete
+func (job *Job) resumeTranslation(ctx context.Context) error {
+	discord.Infof("Resuming deferred translation: %s", job.Input)
+	err := job.runStep(StepTranslation, limited(ctx, &translateSlots, func() error {
+		return job.translateFlow(ctx)
+	}))
+	var exhausted *ai.ExhaustedError
+	if errors.As(err, &exhausted) {
+		return job.deferTranslation(exhausted.Until)
+	}
+	if err != nil {
+		return err
+	}
+	if config.TheConfig.EnableHls && job.StepDone(StepHls) {
+		err = job.writeHlsMaster(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	return job.updateState(Complete)
+}
+
+func (job *Job) mapAudioTracks(ctx context.Context) {
+	mappedAudio := make(map[string][]Stream)
 	for _, audio := range job.Streams {
 		if audio.CodecType != AudioType {
 			continue
 		}
 		for _, codec := range job.EncodedCodecs {
-			id := fmt.Sprintf("%s-%d-%s", codec, audio.Index, audio.Language)
-			cmd := exec.Command(config.TheConfig.Ffmpeg, "-i", job.GetCodecVideo(codec), "-i", job.OutputJoin(audio.Location),
+			id := fmt.Sprintf("%s-%s", codec, audio.Id())
+			cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-i", job.GetCodecVideo(codec), "-i", job.OutputJoin(audio.Location),
 				"-map", "0:v", "-map", "1:a", "-c:v", "copy", "-c:a", "copy", "-shortest", job.OutputJoin(fmt.Sprintf("%s.%s", id, config.TheConfig.VideoExt)))
 			discord.Infof("Command: %s", cmd.String())
 			_, err := utils.RunCommand(cmd)
 			if err != nil {
 				discord.Errorf("error mapping audio tracks: %v", err)
 			} else {
-				if _, ok := job.MappedAudio[codec]; !ok {
-					job.MappedAudio[codec] = make([]Stream, 0)
+				if _, ok := mappedAudio[codec]; !ok {
+					mappedAudio[codec] = make([]Stream, 0)
 				}
-				job.MappedAudio[codec] = append(job.MappedAudio[codec], audio)
+				mappedAudio[codec] = append(mappedAudio[codec], audio)
 			}
 		}
 	}
-	return
+	job.mutex.Lock()
+	job.MappedAudio = mappedAudio
+	job.mutex.Unlock()
 }
 
 func (job *Job) renameAndMove(source string, dest string) {
@@ -402,8 +663,8 @@ func (job *Job) extractDominantColor() (err error) {
 	return nil
 }
 
-func (job *Job) updateDuration(videoFile string) error {
-	out, err := utils.RunCommand(exec.Command(config.TheConfig.Ffprobe, "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", videoFile))
+func (job *Job) updateDuration(ctx context.Context, videoFile string) error {
+	out, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error", "-show_entries", "format=duration", "-of", "default=noprint_wrappers=1:nokey=1", videoFile))
 	if err != nil {
 		discord.Errorf("Error getting video duration: %v\n", err)
 	} else {
@@ -411,7 +672,7 @@ func (job *Job) updateDuration(videoFile string) error {
 		discord.Infof("Container duration: %.2f", job.Duration)
 	}
 
-	actual, err := utils.RunCommand(exec.Command(
+	actual, err := utils.RunCommand(exec.CommandContext(ctx,
 		config.TheConfig.Ffprobe,
 		"-select_streams", "v:0",
 		"-show_entries", "packet=pts_time",
@@ -437,17 +698,17 @@ func (job *Job) updateDuration(videoFile string) error {
 	return nil
 }
 
-func (job *Job) probe() (err error) {
+func (job *Job) probe(ctx context.Context) (err error) {
 	vttFile := job.OutputJoin(ThumbnailVtt)
-	videoFile := job.GetCodecVideo(job.EncodedCodecs[0])
+	videoFile := job.GetCodecVideo(job.previewCodec())
 	thumbnailHeight := config.TheConfig.ThumbnailHeight
 	thumbnailInterval := config.TheConfig.ThumbnailInterval
 	chunkInterval := config.TheConfig.ThumbnailChunkInterval
-	err = job.updateDuration(videoFile)
+	err = job.updateDuration(ctx, videoFile)
 	if err != nil {
 		return
 	}
-	out, err := exec.Command(config.TheConfig.Ffprobe, "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height", "-of", "csv=s=x:p=0", videoFile).Output()
+	out, err := exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error", "-select_streams", "v:0", "-show_entries", "stream=width,height", "-of", "csv=s=x:p=0", videoFile).Output()
 	if err != nil {
 		discord.Errorf("Error getting video aspect ratio: %v\n", err)
 		return
@@ -472,7 +733,7 @@ func (job *Job) probe() (err error) {
 	for i := 0; i < numChunks; i++ {
 		chunkStartTime := i * chunkInterval
 		spriteFile := job.OutputJoin(fmt.Sprintf("%s_%d%s", SpritePrefix, i+1, SpriteExtension))
-		cmd := exec.Command(config.TheConfig.Ffmpeg, "-i", videoFile, "-ss", fmt.Sprintf("%d", chunkStartTime), "-t", fmt.Sprintf("%d", chunkInterval),
+		cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-i", videoFile, "-ss", fmt.Sprintf("%d", chunkStartTime), "-t", fmt.Sprintf("%d", chunkInterval),
 			"-vf", fmt.Sprintf("fps=1/%d,scale=%d:%d,tile=%dx%d", thumbnailInterval, thumbnailWidth, thumbnailHeight, gridSize, gridSize), spriteFile)
 		discord.Infof("Command: %s", cmd.String())
 		_, err = utils.RunCommand(cmd)
@@ -480,6 +741,7 @@ func (job *Job) probe() (err error) {
 			discord.Errorf("Error generating sprite sheet for chunk %d: %v\n", i+1, err)
 			return
 		}
+		job.reportProgress(StageSprites, Progress{Stage: StageSprites, Percent: float64(i+1) / float64(numChunks) * 100})
 
 		for j := 0; j < numThumbnailsPerChunk; j++ {
 			thumbnailTime := i*chunkInterval + j*thumbnailInterval
@@ -512,7 +774,14 @@ func (job *Job) probe() (err error) {
 }
 
 func (job *Job) updateState(newState string) error {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
 	job.State = newState
+	return job.persist()
+}
+
+// persist writes the job to job.json, the caller must hold job.mutex
+func (job *Job) persist() error {
 	jobStr, err := json.Marshal(job)
 	if err != nil {
 		discord.Errorf("error persisting job: %v", err)
@@ -0,0 +1,148 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ProgressFile     = "progress.json"
+	StageEncode      = "encode"
+	StageSprites     = "sprites"
+	progressInterval = 2 * time.Second
+)
+
+// Progress is the state of one running task of a job, e.g. a single encoder
+type Progress struct {
+	Stage     string  `json:"stage"`
+	Percent   float64 `json:"percent"`
+	Fps       float64 `json:"fps,omitempty"`
+	Eta       int64   `json:"eta,omitempty"` // seconds
+	UpdatedAt int64   `json:"updatedAt"`
+}
+
+// JobProgress is persisted to progress.json in the job output so the API server can serve it
+type JobProgress struct {
+	Id        string               `json:"id"`
+	Input     string               `json:"input"`
+	Tasks     map[string]*Progress `json:"tasks"`
+	UpdatedAt int64                `json:"updatedAt"`
+}
+
+type progressTracker struct {
+	JobProgress
+	lastWritten time.Time
+	mutex       sync.Mutex
+}
+
+// reportProgress records the progress of a task and flushes it to disk at most every progressInterval
+func (job *Job) reportProgress(task string, p Progress) {
+	job.mutex.Lock()
+	if job.progress == nil {
+		job.progress = &progressTracker{JobProgress: JobProgress{Id: job.Id, Input: job.Input, Tasks: make(map[string]*Progress)}}
+	}
+	tracker := job.progress
+	job.mutex.Unlock()
+
+	tracker.mutex.Lock()
+	defer tracker.mutex.Unlock()
+	now := time.Now()
+	p.UpdatedAt = now.Unix()
+	tracker.Tasks[task] = &p
+	tracker.UpdatedAt = p.UpdatedAt
+	if now.Sub(tracker.lastWritten) < progressInterval && p.Percent < 100 {
+		return
+	}
+	tracker.lastWritten = now
+	content, err := json.Marshal(tracker.JobProgress)
+	if err != nil {
+		discord.Errorf("error marshalling progress: %v", err)
+		return
+	}
+	err = os.WriteFile(job.OutputJoin(ProgressFile), content, 0644)
+	if err != nil {
+		discord.Errorf("error writing progress: %v", err)
+	}
+}
+
+// handbrakeProgressRegex matches lines like "Encoding: task 1 of 1, 43.21 % (120.34 fps, avg 115.20 fps, ETA 01h12m03s)"
+var handbrakeProgressRegex = regexp.MustCompile(`Encoding: task (\d+) of (\d+), ([\d.]+) %(?: \(([\d.]+) fps, avg ([\d.]+) fps, ETA (\d+)h(\d+)m(\d+)s\))?`)
+
+// handbrakeProgress returns a line handler reporting HandBrakeCLI progress under task
+func (job *Job) handbrakeProgress(task string) func(line string) {
+	return func(line string) {
+		m := handbrakeProgressRegex.FindStringSubmatch(line)
+		if m == nil {
+			return
+		}
+		current, _ := strconv.Atoi(m[1])
+		total, _ := strconv.Atoi(m[2])
+		percent, _ := strconv.ParseFloat(m[3], 64)
+		if total > 1 {
+			percent = (float64(current-1)*100 + percent) / float64(total)
+		}
+		p := Progress{Stage: StageEncode, Percent: percent}
+		if m[4] != "" {
+			p.Fps, _ = strconv.ParseFloat(m[5], 64)
+			hours, _ := strconv.ParseInt(m[6], 10, 64)
+			minutes, _ := strconv.ParseInt(m[7], 10, 64)
+			seconds, _ := strconv.ParseInt(m[8], 10, 64)
+			p.Eta = hours*3600 + minutes*60 + seconds
+		}
+		job.reportProgress(task, p)
+	}
+}
+
+// ffmpegProgress returns a line handler reporting the key=value blocks ffmpeg writes with "-progress pipe:1",
+// duration is the length of the media being processed in seconds.
+func (job *Job) ffmpegProgress(task, stage string, duration float64) func(line string) {
+	var outTime, fps, speed float64
+	return func(line string) {
+		key, value, ok := strings.Cut(strings.TrimSpace(line), "=")
+		if !ok {
+			return
+		}
+		switch key {
+		case "out_time_us":
+			us, err := strconv.ParseFloat(value, 64)
+			if err == nil {
+				outTime = us / 1e6
+			}
+		case "fps":
+			fps, _ = strconv.ParseFloat(value, 64)
+		case "speed":
+			speed, _ = strconv.ParseFloat(strings.TrimSuffix(value, "x"), 64)
+		case "progress":
+			p := Progress{Stage: stage, Fps: fps}
+			if value == "end" {
+				p.Percent = 100
+			} else if duration > 0 {
+				p.Percent = min(outTime/duration*100, 99.99)
+				if speed > 0 {
+					p.Eta = int64((duration - outTime) / speed)
+				}
+			}
+			job.reportProgress(task, p)
+		}
+	}
+}
+
+// mediaDuration returns the container duration of a file in seconds, 0 if unknown
+func mediaDuration(ctx context.Context, file string) float64 {
+	out, err := exec.CommandContext(ctx, config.TheConfig.Ffprobe, "-v", "error", "-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1", file).Output()
+	if err != nil {
+		return 0
+	}
+	duration, _ := strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	return duration
+}
@@ -0,0 +1,83 @@
+package job
+
+import (
+	"Sparkle/config"
+	"context"
+	"sync"
+)
+
+// limiter bounds how many tasks of one kind run at the same time across every job
+type limiter chan struct{}
+
+func (l limiter) acquire(ctx context.Context) error {
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (l limiter) release() {
+	<-l
+}
+
+var (
+	limitersOnce   sync.Once
+	encodeSlots    limiter // CPU heavy encodes
+	extractSlots   limiter // light ffmpeg/ffprobe work
+	translateSlots limiter // AI translation calls
+)
+
+func slots(l *limiter) limiter {
+	limitersOnce.Do(func() {
+		encodeSlots = make(limiter, max(config.TheConfig.EncodeConcurrency, 1))
+		extractSlots = make(limiter, max(config.TheConfig.ExtractConcurrency, 1))
+		translateSlots = make(limiter, max(config.TheConfig.TranslateConcurrency, 1))
+	})
+	return *l
+}
+
+// limited wraps f so it only runs while holding a slot of l
+func limited(ctx context.Context, l *limiter, f func() error) func() error {
+	return func() error {
+		s := slots(l)
+		err := s.acquire(ctx)
+		if err != nil {
+			return err
+		}
+		defer s.release()
+		return f()
+	}
+}
+
+// Queue runs submitted jobs on a fixed number of workers
+type Queue struct {
+	jobs chan *Job
+	wg   sync.WaitGroup
+}
+
+func NewQueue(workers int, run func(j *Job)) *Queue {
+	q := &Queue{jobs: make(chan *Job)}
+	for i := 0; i < max(workers, 1); i++ {
+		q.wg.Add(1)
+		go func() {
+			defer q.wg.Done()
+			for j := range q.jobs {
+				run(j)
+			}
+		}()
+	}
+	return q
+}
+
+// Submit blocks until a worker picks up the job
+func (q *Queue) Submit(j *Job) {
+	q.jobs <- j
+}
+
+// Close stops accepting jobs and waits for the running ones to finish
+func (q *Queue) Close() {
+	close(q.jobs)
+	q.wg.Wait()
+}
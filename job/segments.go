@@ -0,0 +1,224 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/fingerprint"
+	"Sparkle/utils"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Synthetic chapters carry their kind in the "type" tag so the player can offer to skip them
+const (
+	ChapterTypeTag = "type"
+	ChapterIntro   = "intro"
+	ChapterCredits = "credits"
+)
+
+const (
+	segmentMinDuration = 20.0  // seconds, shorter repeats are eyecatches or jingles
+	segmentMaxDuration = 240.0 // seconds, longer repeats are recaps or duplicate releases
+	segmentSiblings    = 4     // episodes compared against
+	introWindow        = 0.4   // share of the runtime an intro has to start in
+	creditsWindow      = 0.3   // share of the runtime at the end credits have to end in
+	fingerprintCache   = 32
+)
+
+var episodeRe = regexp.MustCompile(`S\d+E\d+`)
+
+// segmentTitleRe matches chapter titles naming an opening or ending, generic "Chapter 1" markers don't
+var segmentTitleRe = regexp.MustCompile(`(?i)\b(op|ed|nc ?op|nc ?ed|opening|ending|intro|outro|credits)\b`)
+
+// fingerprints keeps decoded episodes around, every episode of a season is compared against its neighbours
+var fingerprints = struct {
+	sync.Mutex
+	cache map[string]fingerprint.Fingerprint
+}{cache: make(map[string]fingerprint.Fingerprint)}
+
+func audioFingerprint(ctx context.Context, path string) (fingerprint.Fingerprint, error) {
+	stats, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	key := fmt.Sprintf("%s:%d:%d", path, stats.Size(), stats.ModTime().Unix())
+	fingerprints.Lock()
+	fp, ok := fingerprints.cache[key]
+	fingerprints.Unlock()
+	if ok {
+		return fp, nil
+	}
+	cmd := exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-v", "error", "-i", path, "-map", "0:a:0", "-vn",
+		"-ac", "1", "-ar", strconv.Itoa(fingerprint.SampleRate), "-f", "s16le", "-")
+	discord.Infof("Command: %s", cmd.String())
+	out, err := utils.RunCommandOutput(cmd)
+	if err != nil {
+		return nil, err
+	}
+	fp = fingerprint.Compute(fingerprint.Decode(out))
+	fingerprints.Lock()
+	if len(fingerprints.cache) >= fingerprintCache {
+		clear(fingerprints.cache)
+	}
+	fingerprints.cache[key] = fp
+	fingerprints.Unlock()
+	return fp, nil
+}
+
+// siblingEpisodes returns the other episodes next to the input, closest first
+func (job *Job) siblingEpisodes() ([]string, error) {
+	entries, err := os.ReadDir(job.InputJoin())
+	if err != nil {
+		return nil, err
+	}
+	own := episodeRe.FindString(job.Input)
+	names := make([]string, 0)
+	for _, entry := range entries {
+		ext := filepath.Ext(entry.Name())
+		if entry.IsDir() || ext == "" || !slices.Contains(ValidExtensions, ext[1:]) {
+			continue
+		}
+		episode := episodeRe.FindString(entry.Name())
+		if episode == "" || episode == own {
+			continue
+		}
+		names = append(names, entry.Name())
+	}
+	pos, _ := slices.BinarySearch(names, job.Input)
+	distance := func(i int) float64 {
+		return math.Abs(float64(i) - (float64(pos) - 0.5))
+	}
+	order := make([]int, len(names))
+	for i := range order {
+		order[i] = i
+	}
+	slices.SortStableFunc(order, func(x, y int) int {
+		return int(distance(x)*2) - int(distance(y)*2)
+	})
+	siblings := make([]string, 0, segmentSiblings)
+	for _, i := range order[:min(len(order), segmentSiblings)] {
+		siblings = append(siblings, names[i])
+	}
+	return siblings, nil
+}
+
+// detectSegments finds the opening and ending shared with neighbouring episodes and adds them as chapters
+func (job *Job) detectSegments(ctx context.Context) error {
+	if !config.TheConfig.EnableIntroDetection || !episodeRe.MatchString(job.Input) {
+		return nil
+	}
+	if job.hasSegmentChapters() {
+		discord.Infof("Source has opening or ending chapters, skipping intro detection")
+		return nil
+	}
+	// detection is optional, a file it can't read is left without intro and credits chapters
+	siblings, err := job.siblingEpisodes()
+	if err != nil {
+		discord.Errorf("error listing episodes next to %s: %v", job.Input, err)
+		return nil
+	}
+	if len(siblings) == 0 {
+		return nil
+	}
+	own, err := audioFingerprint(ctx, job.InputJoin(job.Input))
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		discord.Errorf("error fingerprinting %s: %v", job.Input, err)
+		return nil
+	}
+	duration := own.Duration()
+	// every sibling proposes its longest intro and credits, the ones most siblings agree on are kept
+	var intros, credits []fingerprint.Match
+	for _, sibling := range siblings {
+		other, err := audioFingerprint(ctx, job.InputJoin(sibling))
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			discord.Errorf("error fingerprinting %s: %v", sibling, err)
+			continue
+		}
+		var intro, credit *fingerprint.Match
+		for _, m := range fingerprint.Matches(own, other, segmentMinDuration) {
+			if m.Duration() > segmentMaxDuration {
+				continue
+			}
+			if m.StartA < duration*introWindow {
+				if intro == nil || m.Duration() > intro.Duration() {
+					intro = &m
+				}
+			} else if m.EndA > duration*(1-creditsWindow) {
+				if credit == nil || m.Duration() > credit.Duration() {
+					credit = &m
+				}
+			}
+		}
+		if intro != nil {
+			intros = append(intros, *intro)
+		}
+		if credit != nil {
+			credits = append(credits, *credit)
+		}
+	}
+	if intro, ok := bestSupported(intros); ok {
+		job.Chapters = append(job.Chapters, segmentChapter(len(job.Chapters), ChapterIntro, "Intro", intro))
+	}
+	if credit, ok := bestSupported(credits); ok {
+		job.Chapters = append(job.Chapters, segmentChapter(len(job.Chapters), ChapterCredits, "Credits", credit))
+	}
+	discord.Infof("Detected segments: %+v", job.Chapters)
+	return nil
+}
+
+// hasSegmentChapters tells whether the source already marks its opening or ending
+func (job *Job) hasSegmentChapters() bool {
+	for _, chapter := range job.Chapters {
+		if title, ok := chapter.Tags["title"].(string); ok && segmentTitleRe.MatchString(title) {
+			return true
+		}
+	}
+	return false
+}
+
+// bestSupported returns the match overlapping the most others, the longer one among equally supported
+// ones, so a single neighbour with a different opening or a recap is outvoted
+func bestSupported(matches []fingerprint.Match) (fingerprint.Match, bool) {
+	best, bestSupport := -1, 0
+	for i, m := range matches {
+		support := 0
+		for _, other := range matches {
+			if m.StartA < other.EndA && other.StartA < m.EndA {
+				support++
+			}
+		}
+		if support > bestSupport || support == bestSupport && m.Duration() > matches[best].Duration() {
+			best, bestSupport = i, support
+		}
+	}
+	if best < 0 {
+		return fingerprint.Match{}, false
+	}
+	return matches[best], true
+}
+
+func segmentChapter(id int, kind, title string, m fingerprint.Match) Chapter {
+	return Chapter{
+		ID:        int64(id),
+		StartTime: fmt.Sprintf("%.6f", m.StartA),
+		EndTime:   fmt.Sprintf("%.6f", m.EndA),
+		Start:     int(m.StartA * 1000),
+		End:       int(m.EndA * 1000),
+		TimeBase:  "1/1000",
+		Tags:      map[string]interface{}{"title": title, ChapterTypeTag: kind},
+	}
+}
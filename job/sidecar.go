@@ -0,0 +1,107 @@
+package job
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/translation"
+	"Sparkle/utils"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// sidecarIndexBase numbers sidecar streams after the container's so their ids never clash
+const sidecarIndexBase = 100
+
+// sourceSidecars lists the subtitle files next to path. Sidecars in a translation language are left out,
+// they are usually what an earlier run of the subtitles command wrote there.
+func sourceSidecars(path string) []translation.Sidecar {
+	sidecars, err := translation.FindSidecars(path)
+	if err != nil {
+		discord.Errorf("error listing sidecars of %s: %v", path, err)
+		return nil
+	}
+	var targets []string
+	for _, languageWithCode := range config.TheConfig.TranslationLanguages {
+		if ss := strings.Split(languageWithCode, ";"); len(ss) > 1 {
+			targets = append(targets, strings.ToLower(ss[1]))
+		}
+	}
+	return slices.DeleteFunc(sidecars, func(s translation.Sidecar) bool {
+		return slices.Contains(targets, s.Language)
+	})
+}
+
+// extractSidecars writes every sidecar of the input as ass, vtt and srt streams like the extracted ones
+// and returns how many were usable
+func (job *Job) extractSidecars(ctx context.Context) int {
+	usable := 0
+	for i, sidecar := range sourceSidecars(job.InputJoin(job.Input)) {
+		content, err := os.ReadFile(sidecar.Path)
+		if err != nil {
+			discord.Errorf("error reading sidecar %s: %v", sidecar.Path, err)
+			continue
+		}
+		id := fmt.Sprintf("%d-%s", sidecarIndexBase+i, sidecar.Language)
+		vtt := string(content)
+		switch sidecar.Format {
+		case "srt":
+			vtt = translation.SrtToVTT(vtt)
+		case "ass":
+			vtt, err = translation.ConvertAssToVTT(vtt)
+			if err != nil {
+				discord.Errorf("error converting sidecar %s: %v", sidecar.Path, err)
+				continue
+			}
+		}
+		srt := string(content)
+		if sidecar.Format != "srt" {
+			srt = translation.VttToSRT(vtt)
+		}
+
+		outputs := []struct {
+			codec, filename string
+			write           func(dest string) error
+		}{
+			{"ass", id + ".ass", func(dest string) error {
+				if sidecar.Format == "ass" {
+					_, err := utils.CopyFile(sidecar.Path, dest)
+					return err
+				}
+				_, err := utils.RunCommand(exec.CommandContext(ctx, config.TheConfig.Ffmpeg, "-y", "-i", sidecar.Path, dest))
+				return err
+			}},
+			{"webvtt", id + ".vtt", func(dest string) error {
+				return os.WriteFile(dest, []byte(vtt), 0644)
+			}},
+			{"subrip", id + ".srt", func(dest string) error {
+				return os.WriteFile(dest, []byte(srt), 0644)
+			}},
+		}
+		written := false
+		for _, output := range outputs {
+			err = output.write(job.OutputJoin(output.filename))
+			if err != nil {
+				discord.Errorf("error converting sidecar %s to %s: %v", sidecar.Path, output.codec, err)
+				continue
+			}
+			written = true
+			job.addStream(Stream{
+				CodecName: output.codec,
+				CodecType: SubtitlesType,
+				Index:     sidecarIndexBase + i,
+				Language:  sidecar.Language,
+				Title:     filepath.Base(sidecar.Path),
+				Location:  output.filename,
+			})
+		}
+		if written {
+			usable++
+		}
+	}
+	return usable
+}
@@ -0,0 +1,90 @@
+package job
+
+import (
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"encoding/json"
+	"os"
+	"slices"
+	"time"
+)
+
+// Pipeline steps recorded in Job.Steps once they finish, a resumed job skips everything already recorded
+const (
+	StepThumbnailsNfo = "thumbnails_nfo"
+	StepChapters      = "chapters"
+	StepSegments      = "segments" // intro and credits chapters
+	StepSubtitles     = "subtitles"
+	StepTranslation   = "translation"
+	StepAttachments   = "attachments"
+	StepEncode        = "encode" // recorded per rendition as encode:<name>
+	StepAudioMapping  = "audio_mapping"
+	StepSprites       = "sprites"
+	StepHls           = "hls"
+)
+
+func encodeStep(rendition string) string {
+	return StepEncode + ":" + rendition
+}
+
+// Load reads a persisted job back from its job.json
+func Load(id string) (*Job, error) {
+	content, err := os.ReadFile(utils.OutputJoin(id, JobFile))
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	err = json.Unmarshal(content, job)
+	if err != nil {
+		return nil, err
+	}
+	return job, nil
+}
+
+// Resumable reports whether a persisted job can continue processing the given input instead of starting over,
+// a completed job with a deferred translation resumes to run it
+func (job *Job) Resumable(root, parent, input string, size int64, fast, translate bool) bool {
+	return (job.State != Complete || job.TranslationDeferredUntil != 0) && len(job.Steps) > 0 &&
+		(job.InputRoot == "" || job.InputRoot == root) &&
+		job.InputParent == parent && job.Input == input && job.OriSize == size &&
+		job.Fast == fast && job.Translate == translate
+}
+
+func (job *Job) StepDone(step string) bool {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	return slices.Contains(job.Steps, step)
+}
+
+// deferTranslation lets the rest of the pipeline go on without the translation, the next scan after until
+// resumes the job to run it
+func (job *Job) deferTranslation(until time.Time) error {
+	discord.Infof("Deferring translation of %s until %s", job.Input, until.Format(time.RFC3339))
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	job.TranslationDeferredUntil = until.Unix()
+	return job.persist()
+}
+
+func (job *Job) markStep(step string) error {
+	job.mutex.Lock()
+	defer job.mutex.Unlock()
+	if !slices.Contains(job.Steps, step) {
+		job.Steps = append(job.Steps, step)
+	}
+	return job.persist()
+}
+
+// runStep runs f unless step was completed by a previous run, and records it once it succeeds
+func (job *Job) runStep(step string, f func() error) error {
+	if job.StepDone(step) {
+		discord.Infof("Skipping completed step: %s", step)
+		return nil
+	}
+	discord.Infof("Running step: %s", step)
+	err := f()
+	if err != nil {
+		return err
+	}
+	return job.markStep(step)
+}
@@ -16,32 +16,35 @@ import (
 	"sync"
 )
 
-func loop(matches func(s string) bool, te ToEncode, runner func(file os.DirEntry, parent string, te ToEncode) bool) error {
-	files, err := os.ReadDir(config.TheConfig.Input)
+// Runner handles one file found under root, in the directory parent of root or in root itself when parent is empty
+type Runner func(file os.DirEntry, root, parent string, te ToEncode) bool
+
+func loop(root string, matches func(s string) bool, te ToEncode, runner Runner) error {
+	files, err := os.ReadDir(root)
 	if err != nil {
 		return err
 	}
 	for _, file := range files {
 		if file.IsDir() {
-			fs, err := os.ReadDir(utils.InputJoin(file.Name()))
+			fs, err := os.ReadDir(filepath.Join(root, file.Name()))
 			if err != nil {
 				return err
 			}
 			for _, f := range fs {
 				if matches == nil || matches(f.Name()) {
-					runner(f, file.Name(), te)
+					runner(f, root, file.Name(), te)
 				}
 			}
 		} else {
 			if matches == nil || matches(file.Name()) {
-				runner(file, "", te)
+				runner(file, root, "", te)
 			}
 		}
 	}
 	return nil
 }
 
-func LoopShows(root string, shows []Show, runner func(file os.DirEntry, parent string, te ToEncode) bool) {
+func LoopShows(root string, shows []Show, runner Runner) {
 	files, err := os.ReadDir(root)
 	if err != nil {
 		discord.Errorf("error reading directory: %v", err)
@@ -60,8 +63,7 @@ func LoopShows(root string, shows []Show, runner func(file os.DirEntry, parent s
 						p := func(matches func(s string) bool) {
 							root := filepath.Join(root, file.Name(), f.Name())
 							discord.Infof("Scanning %s", root)
-							config.TheConfig.Input = root
-							err := loop(matches, show.ToEncode, runner)
+							err := loop(root, matches, show.ToEncode, runner)
 							if err != nil {
 								discord.Errorf("error: %v", err)
 							}
@@ -116,7 +118,7 @@ func LoopShows(root string, shows []Show, runner func(file os.DirEntry, parent s
 	}
 }
 
-func LoopMovies(root string, movies []Movie, runner func(file os.DirEntry, parent string, te ToEncode) bool) {
+func LoopMovies(root string, movies []Movie, runner Runner) {
 	files, err := os.ReadDir(root)
 	if err != nil {
 		discord.Errorf("error reading directory: %v", err)
@@ -128,8 +130,7 @@ func LoopMovies(root string, movies []Movie, runner func(file os.DirEntry, paren
 				if strings.Contains(strings.ToLower(file.Name()), strings.ToLower(movie.Name)) {
 					root := filepath.Join(root, file.Name())
 					discord.Infof("Processing %s", root)
-					config.TheConfig.Input = root
-					err = loop(nil, movie.ToEncode, runner)
+					err = loop(root, nil, movie.ToEncode, runner)
 					if err != nil {
 						discord.Errorf("error: %v", err)
 					}
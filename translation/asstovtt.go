@@ -0,0 +1,394 @@
+package translation
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// assStyle is the part of an ASS style WebVTT is able to express
+type assStyle struct {
+	Name      string
+	Color     string // #rrggbb
+	Bold      bool
+	Italic    bool
+	Underline bool
+	Alignment int // numpad layout, 2 is bottom center
+}
+
+// vttCue is a converted dialogue line, Settings are WebVTT cue settings like "line:0 align:start"
+type vttCue struct {
+	Start    time.Duration
+	End      time.Duration
+	Settings string
+	Text     string
+}
+
+var (
+	overrideTagRegex = regexp.MustCompile(`\\(an|[1-4]?c|pos|fn|fs|i|b|u|s|a|r)([^\\]*)`)
+	posRegex         = regexp.MustCompile(`^\(\s*(-?[\d.]+)\s*,\s*(-?[\d.]+)\s*\)`)
+	cssClassRegex    = regexp.MustCompile(`[^a-zA-Z0-9_-]`)
+	vttTagRegex      = regexp.MustCompile(`</?[a-z][^>]*>`)
+)
+
+// AssToVTT converts an .ass file to a .vtt file next to it
+func AssToVTT(file string) error {
+	fBytes, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	vtt, err := ConvertAssToVTT(string(fBytes))
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(strings.ReplaceAll(file, ".ass", ".vtt"), []byte(vtt), 0644)
+}
+
+// ConvertAssToVTT converts the translatable dialogue of an ASS script to WebVTT, alignment and \pos become
+// cue settings, styles and colours become ::cue classes in a STYLE block.
+func ConvertAssToVTT(input string) (string, error) {
+	headers, translatable, err := sanitizeInputASS(strings.ReplaceAll(input, "\r\n", "\n"))
+	if err != nil {
+		return "", err
+	}
+	playResX, playResY := 384.0, 288.0
+	styles := make(map[string]assStyle)
+	var styleFormat string
+	var start, end, style, text int
+	for _, line := range strings.Split(headers, "\n") {
+		line = strings.TrimSpace(line)
+		key, value, _ := strings.Cut(line, ":")
+		switch strings.ToLower(key) {
+		case "playresx":
+			playResX = parsePositive(value, playResX)
+		case "playresy":
+			playResY = parsePositive(value, playResY)
+		case "format":
+			if isFormatLine(line) {
+				start, end, style, text = findField(line, "start"), findField(line, "end"), findField(line, "style"), findField(line, "text")
+			} else {
+				styleFormat = line
+			}
+		case "style":
+			if styleFormat != "" {
+				s := parseAssStyle(styleFormat, value)
+				styles[s.Name] = s
+			}
+		}
+	}
+
+	classes := make(map[string]string)
+	var cues []vttCue
+	for _, line := range strings.Split(translatable, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		cueStart, err1 := parseAssTime(extractDialogueField(line, start, false))
+		cueEnd, err2 := parseAssTime(extractDialogueField(line, end, false))
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		s, ok := styles[extractDialogueField(line, style, false)]
+		if !ok {
+			s = assStyle{Name: extractDialogueField(line, style, false), Alignment: 2}
+		}
+		cue := convertDialogue(extractDialogueField(line, text, true), s, styles, playResX, playResY, classes)
+		if cue.Text == "" {
+			continue
+		}
+		cue.Start, cue.End = cueStart, cueEnd
+		cues = append(cues, cue)
+	}
+	slices.SortStableFunc(cues, func(a, b vttCue) int {
+		return int(a.Start - b.Start)
+	})
+
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	if len(classes) > 0 {
+		names := make([]string, 0, len(classes))
+		for name := range classes {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+		sb.WriteString("STYLE\n")
+		for _, name := range names {
+			sb.WriteString(fmt.Sprintf("::cue(.%s) { %s }\n", name, classes[name]))
+		}
+		sb.WriteString("\n")
+	}
+	for _, cue := range cues {
+		sb.WriteString(formatVTTTime(cue.Start) + " --> " + formatVTTTime(cue.End))
+		if cue.Settings != "" {
+			sb.WriteString(" " + cue.Settings)
+		}
+		sb.WriteString("\n" + cue.Text + "\n\n")
+	}
+	return sb.String(), nil
+}
+
+func parseAssStyle(format, value string) assStyle {
+	fields := strings.Split(value, ",")
+	field := func(name string) string {
+		i := findField(format, name)
+		if i < 0 || i >= len(fields) {
+			return ""
+		}
+		return strings.TrimSpace(fields[i])
+	}
+	s := assStyle{
+		Name:      field("name"),
+		Color:     assColor(field("primarycolour")),
+		Bold:      assFlag(field("bold")),
+		Italic:    assFlag(field("italic")),
+		Underline: assFlag(field("underline")),
+		Alignment: 2,
+	}
+	if a, err := strconv.Atoi(field("alignment")); err == nil && a >= 1 && a <= 9 {
+		s.Alignment = a
+	}
+	return s
+}
+
+// convertDialogue turns the text field of a dialogue line into a cue, registering the css classes it uses
+func convertDialogue(text string, style assStyle, styles map[string]assStyle, playResX, playResY float64, classes map[string]string) vttCue {
+	alignment := style.Alignment
+	var pos []float64
+	current := style
+	var sb strings.Builder
+	var open []string // closing tags of the spans opened since the last reset
+	closeAll := func() {
+		for i := len(open) - 1; i >= 0; i-- {
+			sb.WriteString(open[i])
+		}
+		open = nil
+	}
+	// apply opens the tags needed to go from the style of the line to current
+	apply := func() {
+		closeAll()
+		if current.Color != "" && current.Color != style.Color {
+			name := "c" + strings.TrimPrefix(current.Color, "#")
+			classes[name] = "color: " + current.Color + ";"
+			sb.WriteString("<c." + name + ">")
+			open = append(open, "</c>")
+		}
+		for _, t := range []struct {
+			on, base bool
+			tag      string
+		}{{current.Bold, style.Bold, "b"}, {current.Italic, style.Italic, "i"}, {current.Underline, style.Underline, "u"}} {
+			if t.on && !t.base {
+				sb.WriteString("<" + t.tag + ">")
+				open = append(open, "</"+t.tag+">")
+			}
+		}
+	}
+
+	rest := text
+	for rest != "" {
+		loc := overrideBlockRegex.FindStringIndex(rest)
+		plain := rest
+		if loc != nil {
+			plain = rest[:loc[0]]
+		}
+		sb.WriteString(assTextToVTT(plain))
+		if loc == nil {
+			break
+		}
+		block := rest[loc[0]+1 : loc[1]-1]
+		rest = rest[loc[1]:]
+		changed := false
+		for _, m := range overrideTagRegex.FindAllStringSubmatch(block, -1) {
+			tag, arg := m[1], strings.TrimSpace(m[2])
+			if tag == "i" || tag == "b" || tag == "u" {
+				// \bord, \blur, \be and friends share the prefix
+				if _, err := strconv.Atoi(arg); arg != "" && err != nil {
+					continue
+				}
+			}
+			switch tag {
+			case "an":
+				if a, err := strconv.Atoi(arg); err == nil && a >= 1 && a <= 9 {
+					alignment = a
+				}
+			case "a":
+				if a, err := strconv.Atoi(arg); err == nil {
+					alignment = legacyAlignment(a, alignment)
+				}
+			case "pos":
+				if p := posRegex.FindStringSubmatch(arg); p != nil {
+					x, _ := strconv.ParseFloat(p[1], 64)
+					y, _ := strconv.ParseFloat(p[2], 64)
+					pos = []float64{x, y}
+				}
+			case "c", "1c":
+				color := assColor(arg)
+				if arg == "" {
+					color = style.Color
+				} else if color == "" {
+					continue
+				}
+				current.Color = color
+				changed = true
+			case "i":
+				current.Italic = style.Italic
+				if arg != "" {
+					current.Italic = assFlag(arg)
+				}
+				changed = true
+			case "b":
+				current.Bold = style.Bold
+				if arg != "" {
+					current.Bold = assFlag(arg)
+				}
+				changed = true
+			case "u":
+				current.Underline = style.Underline
+				if arg != "" {
+					current.Underline = assFlag(arg)
+				}
+				changed = true
+			case "r":
+				current = style
+				if s, ok := styles[arg]; ok {
+					current = s
+				}
+				changed = true
+			}
+		}
+		if changed {
+			apply()
+		}
+	}
+	closeAll()
+
+	cueText := strings.TrimSpace(sb.String())
+	if strings.TrimSpace(vttTagRegex.ReplaceAllString(cueText, "")) == "" {
+		return vttCue{}
+	}
+	if name := cssClassRegex.ReplaceAllString(style.Name, "_"); name != "" {
+		if css := styleCSS(style); css != "" {
+			name = "s_" + name
+			classes[name] = css
+			cueText = "<c." + name + ">" + cueText + "</c>"
+		}
+	}
+	return vttCue{Settings: cueSettings(alignment, pos, playResX, playResY), Text: cueText}
+}
+
+// cueSettings maps the numpad alignment and an optional \pos to WebVTT line, position and align settings
+func cueSettings(alignment int, pos []float64, playResX, playResY float64) string {
+	column := (alignment - 1) % 3 // 0 left, 1 center, 2 right
+	row := (alignment - 1) / 3    // 0 bottom, 1 middle, 2 top
+	align := []string{"start", "center", "end"}[column]
+	var settings []string
+	if pos != nil {
+		x := min(max(pos[0]/playResX*100, 0), 100)
+		y := min(max(pos[1]/playResY*100, 0), 100)
+		lineAlign := []string{"end", "center", "start"}[row]
+		positionAlign := []string{"line-left", "center", "line-right"}[column]
+		settings = append(settings, fmt.Sprintf("line:%.2f%%,%s", y, lineAlign), fmt.Sprintf("position:%.2f%%,%s", x, positionAlign))
+	} else {
+		switch row {
+		case 1:
+			settings = append(settings, "line:50%,center")
+		case 2:
+			settings = append(settings, "line:0")
+		}
+	}
+	if column != 1 || pos != nil {
+		settings = append(settings, "align:"+align)
+	}
+	return strings.Join(settings, " ")
+}
+
+// legacyAlignment converts \a values (1-3 bottom, 5-7 top, 9-11 middle) to numpad alignment
+func legacyAlignment(a, fallback int) int {
+	switch {
+	case a >= 1 && a <= 3:
+		return a
+	case a >= 5 && a <= 7:
+		return a + 2
+	case a >= 9 && a <= 11:
+		return a - 5
+	}
+	return fallback
+}
+
+func styleCSS(s assStyle) string {
+	var css []string
+	if s.Color != "" && s.Color != "#ffffff" {
+		css = append(css, "color: "+s.Color+";")
+	}
+	if s.Bold {
+		css = append(css, "font-weight: bold;")
+	}
+	if s.Italic {
+		css = append(css, "font-style: italic;")
+	}
+	if s.Underline {
+		css = append(css, "text-decoration: underline;")
+	}
+	return strings.Join(css, " ")
+}
+
+// assTextToVTT escapes plain ASS text and converts its line breaks and hard spaces
+func assTextToVTT(text string) string {
+	text = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(text)
+	return strings.NewReplacer(`\N`, "\n", `\n`, "\n", `\h`, "\u00a0").Replace(text)
+}
+
+// assColor converts &HAABBGGRR& or &HBBGGRR& to #rrggbb, the alpha channel is dropped
+func assColor(value string) string {
+	value = strings.Trim(strings.TrimSpace(value), "&")
+	value = strings.TrimPrefix(strings.TrimPrefix(value, "H"), "h")
+	n, err := strconv.ParseUint(value, 16, 32)
+	if err != nil {
+		return ""
+	}
+	return fmt.Sprintf("#%02x%02x%02x", n&0xff, n>>8&0xff, n>>16&0xff)
+}
+
+// assFlag parses style and tag booleans, styles use -1 for true, \b also accepts font weights
+func assFlag(value string) bool {
+	n, err := strconv.Atoi(strings.TrimSpace(value))
+	return err == nil && (n == -1 || n == 1 || n >= 100 && n != 400)
+}
+
+func parsePositive(value string, fallback float64) float64 {
+	f, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+	if err != nil || f <= 0 {
+		return fallback
+	}
+	return f
+}
+
+// parseAssTime parses h:mm:ss.cc
+func parseAssTime(value string) (time.Duration, error) {
+	parts := strings.Split(strings.TrimSpace(value), ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid ASS time: %s", value)
+	}
+	hours, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0, err
+	}
+	minutes, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return 0, err
+	}
+	seconds, err := strconv.ParseFloat(parts[2], 64)
+	if err != nil {
+		return 0, err
+	}
+	return time.Duration(hours)*time.Hour + time.Duration(minutes)*time.Minute +
+		time.Duration(seconds*float64(time.Second)).Round(time.Millisecond), nil
+}
+
+func formatVTTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d.%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,48 @@
+package translation
+
+import (
+	"strings"
+	"testing"
+)
+
+const assSample = `[Script Info]
+ScriptType: v4.00+
+PlayResX: 1920
+PlayResY: 1080
+
+[V4+ Styles]
+Format: Name, Fontname, Fontsize, PrimaryColour, SecondaryColour, OutlineColour, BackColour, Bold, Italic, Underline, StrikeOut, ScaleX, ScaleY, Spacing, Angle, BorderStyle, Outline, Shadow, Alignment, MarginL, MarginR, MarginV, Encoding
+Style: Default,Arial,60,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,1,2,10,10,40,1
+Style: Thoughts,Arial,60,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,-1,0,0,100,100,0,0,1,2,1,8,10,10,40,1
+
+[Events]
+Format: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text
+Dialogue: 0,0:00:05.00,0:00:07.50,Default,,0,0,0,,Second line
+Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,Hello {\i1}there{\i0}\Nfriend
+Dialogue: 0,0:00:03.00,0:00:04.00,Thoughts,,0,0,0,,I wonder
+Dialogue: 0,0:00:08.00,0:00:10.00,Default,,0,0,0,,{\an7\pos(960,540)\c&H0000FF&}Sign, text
+Dialogue: 0,0:00:11.00,0:00:12.00,Default,,0,0,0,,{\bord3\b1}Loud{\b0} & <clear>
+`
+
+func TestConvertAssToVTT(t *testing.T) {
+	vtt, err := ConvertAssToVTT(assSample)
+	if err != nil {
+		t.Fatalf("Failed to convert: %v", err)
+	}
+	for _, want := range []string{
+		"WEBVTT\n\nSTYLE\n",
+		"::cue(.cff0000) { color: #ff0000; }",
+		"::cue(.s_Thoughts) { font-style: italic; }",
+		"00:00:01.000 --> 00:00:03.000\nHello <i>there</i>\nfriend\n",
+		"00:00:03.000 --> 00:00:04.000 line:0\n<c.s_Thoughts>I wonder</c>\n",
+		"00:00:08.000 --> 00:00:10.000 line:50.00%,start position:50.00%,line-left align:start\n<c.cff0000>Sign, text</c>\n",
+		"00:00:11.000 --> 00:00:12.000\n<b>Loud</b> &amp; &lt;clear&gt;\n",
+	} {
+		if !strings.Contains(vtt, want) {
+			t.Errorf("Output is missing %q:\n%s", want, vtt)
+		}
+	}
+	if strings.Index(vtt, "00:00:01.000") > strings.Index(vtt, "00:00:05.000") {
+		t.Errorf("Cues are not sorted by start time:\n%s", vtt)
+	}
+}
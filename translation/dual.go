@@ -0,0 +1,162 @@
+package translation
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DualVariant marks the bilingual copy of a translation, written as <code>.dual.<ext> next to it
+const DualVariant = "dual"
+
+// dualStyle is the ASS style of the original lines, a smaller copy of the main style placed at the top
+const dualStyle = "Original"
+
+// dualScale is the size of the original lines relative to the translation
+const dualScale = 0.75
+
+var positionedRegex = regexp.MustCompile(`\\(pos|move|an\d)`)
+
+// DualPath returns where the bilingual copy of the translation at dest goes
+func DualPath(dest string) string {
+	ext := filepath.Ext(dest)
+	return strings.TrimSuffix(dest, ext) + "." + DualVariant + ext
+}
+
+// MergeDualASS adds the original dialogue to a translated ASS script in a smaller style at the top of the screen.
+// Positioned lines like signs are left out, the translation already sits in their place.
+func MergeDualASS(headers, original, translated string) (string, error) {
+	text, style := -1, -1
+	for _, line := range strings.Split(headers, "\n") {
+		if isFormatLine(line) {
+			text, style = findField(line, "text"), findField(line, "style")
+		}
+	}
+	if text < 0 || style < 0 {
+		return "", fmt.Errorf("no events format line found")
+	}
+	var originals []string
+	for _, line := range strings.Split(original, "\n") {
+		fields := strings.SplitN(line, ",", text+1)
+		if len(fields) <= text || positionedRegex.MatchString(fields[text]) {
+			continue
+		}
+		body := strings.TrimSpace(overrideBlockRegex.ReplaceAllString(fields[text], ""))
+		if body == "" {
+			continue
+		}
+		fields[style] = dualStyle
+		fields[text] = body
+		originals = append(originals, strings.Join(fields, ","))
+	}
+
+	lines := strings.Split(translated, "\n")
+	styleFormat, lastStyle := "", -1
+	for i, line := range lines {
+		key, _, _ := strings.Cut(strings.TrimSpace(line), ":")
+		switch strings.ToLower(key) {
+		case "format":
+			if !isFormatLine(line) && styleFormat == "" {
+				styleFormat = line
+			}
+		case "style":
+			lastStyle = i
+		}
+	}
+	if lastStyle < 0 {
+		return "", fmt.Errorf("no style found")
+	}
+	originalStyle := dualStyleLine(styleFormat, lines, lastStyle)
+	out := make([]string, 0, len(lines)+len(originals)+1)
+	for i, line := range lines {
+		out = append(out, line)
+		if i == lastStyle {
+			out = append(out, originalStyle)
+		} else if isFormatLine(line) && len(originals) > 0 {
+			out = append(out, originals...)
+		}
+	}
+	return strings.Join(out, "\n"), nil
+}
+
+// dualStyleLine copies the Default style, or the last one if there is none, into the style of the original lines
+func dualStyleLine(format string, lines []string, lastStyle int) string {
+	base := lines[lastStyle]
+	for _, line := range lines {
+		_, value, _ := strings.Cut(line, ":")
+		if strings.HasPrefix(strings.TrimSpace(line), "Style:") &&
+			strings.EqualFold(strings.TrimSpace(strings.Split(value, ",")[0]), "default") {
+			base = line
+		}
+	}
+	_, value, _ := strings.Cut(base, ":")
+	fields := strings.Split(strings.TrimSpace(value), ",")
+	set := func(name, v string) {
+		if i := findField(format, name); i >= 0 && i < len(fields) {
+			fields[i] = v
+		}
+	}
+	set("name", dualStyle)
+	set("alignment", "8")
+	if i := findField(format, "fontsize"); i >= 0 && i < len(fields) {
+		if size, err := strconv.ParseFloat(strings.TrimSpace(fields[i]), 64); err == nil {
+			fields[i] = strconv.Itoa(int(math.Round(size * dualScale)))
+		}
+	}
+	return "Style: " + strings.Join(fields, ",")
+}
+
+// MergeDualVTT stacks the cues of the original above the translated ones, in a smaller font
+func MergeDualVTT(original, translated string) string {
+	type cue struct {
+		start time.Duration
+		block string
+	}
+	var cues []cue
+	add := func(blocks []vttBlock, isOriginal bool) {
+		for _, b := range blocks {
+			start, _ := parseVTTTime(strings.Fields(b.timing)[0])
+			if isOriginal {
+				timing := b.timing
+				if !strings.Contains(timing, "line:") {
+					timing += " line:0"
+				}
+				cues = append(cues, cue{start, timing + "\n<c." + dualStyle + ">" + b.text + "</c>"})
+			} else {
+				cues = append(cues, cue{start, b.timing + "\n" + b.text})
+			}
+		}
+	}
+	add(vttBlocks(original), true)
+	add(vttBlocks(translated), false)
+	slices.SortStableFunc(cues, func(a, b cue) int {
+		return int(a.start - b.start)
+	})
+
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	sb.WriteString(fmt.Sprintf("STYLE\n::cue(.%s) { font-size: %d%%; }\n\n", dualStyle, int(dualScale*100)))
+	for _, c := range cues {
+		sb.WriteString(c.block + "\n\n")
+	}
+	return sb.String()
+}
+
+// parseVTTTime parses mm:ss.ttt and hh:mm:ss.ttt
+func parseVTTTime(value string) (time.Duration, error) {
+	parts := strings.Split(strings.ReplaceAll(value, ",", "."), ":")
+	var total float64
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(p, 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid time %q: %w", value, err)
+		}
+		total = total*60 + v
+	}
+	return time.Duration(total * float64(time.Second)), nil
+}
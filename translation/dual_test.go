@@ -0,0 +1,42 @@
+package translation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMergeDualASS(t *testing.T) {
+	headers, original, err := sanitizeInputASS(assSample)
+	if err != nil {
+		t.Fatalf("Failed to sanitize: %v", err)
+	}
+	translated := sanitizeOutputASS(headers, "Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,Hallo")
+	dual, err := MergeDualASS(headers, original, translated)
+	if err != nil {
+		t.Fatalf("Failed to merge: %v", err)
+	}
+	for _, want := range []string{
+		"Style: Original,Arial,45,&H00FFFFFF,&H000000FF,&H00000000,&H00000000,0,0,0,0,100,100,0,0,1,2,1,8,10,10,40,1",
+		"Dialogue: 0,0:00:01.00,0:00:03.00,Original,,0,0,0,,Hello there\\Nfriend",
+		"Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,Hallo",
+	} {
+		if !strings.Contains(dual, want) {
+			t.Errorf("Output is missing %q:\n%s", want, dual)
+		}
+	}
+	if strings.Contains(dual, "Original,,0,0,0,,Sign, text") {
+		t.Errorf("Positioned line was added:\n%s", dual)
+	}
+}
+
+func TestMergeDualVTT(t *testing.T) {
+	dual := MergeDualVTT("WEBVTT\n\n00:02.000 --> 00:03.000\nWorld\n\n00:00:01.000 --> 00:00:02.000\nHello\n",
+		"WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nHallo\n\n00:00:02.000 --> 00:00:03.000\nWelt\n")
+	want := "00:00:01.000 --> 00:00:02.000 line:0\n<c.Original>Hello</c>\n\n" +
+		"00:00:01.000 --> 00:00:02.000\nHallo\n\n" +
+		"00:02.000 --> 00:03.000 line:0\n<c.Original>World</c>\n\n" +
+		"00:00:02.000 --> 00:00:03.000\nWelt\n\n"
+	if !strings.HasSuffix(dual, want) {
+		t.Errorf("Unexpected output:\n%s", dual)
+	}
+}
@@ -0,0 +1,413 @@
+package translation
+
+import (
+	"Sparkle/ai"
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// LinesMode sends only the dialogue text as numbered JSON entries and merges the translations back in Go,
+// timings and override tags never go through the model.
+const LinesMode = "lines"
+
+// maxSegments is the most inputs ai.SendWithRetrySplit accepts at once
+const maxSegments = 15
+
+type lineEntry struct {
+	Id   int    `json:"id"`
+	Text string `json:"text"`
+}
+
+type lineBatch struct {
+	Lines []lineEntry `json:"lines"`
+}
+
+var linesSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"lines": map[string]interface{}{
+			"type": "array",
+			"items": map[string]interface{}{
+				"type": "object",
+				"properties": map[string]interface{}{
+					"id":   map[string]interface{}{"type": "integer"},
+					"text": map[string]interface{}{"type": "string"},
+				},
+				"required":             []string{"id", "text"},
+				"additionalProperties": false,
+			},
+		},
+	},
+	"required":             []string{"lines"},
+	"additionalProperties": false,
+}
+
+var (
+	leadingBlocksRegex  = regexp.MustCompile(`^(\{[^}]*})+`)
+	trailingBlocksRegex = regexp.MustCompile(`(\{[^}]*})+$`)
+	// placeholderRegex matches the {1}, {2}... standing in for override blocks in the middle of a line,
+	// ASS has no literal braces so they can't be mistaken for text
+	placeholderRegex = regexp.MustCompile(`\{(\d+)}`)
+)
+
+// subtitleLines is a subtitle file taken apart into translatable entries and everything around them
+type subtitleLines struct {
+	entries []lineEntry
+	// durations holds how long each entry is on screen, when its timing could be parsed
+	durations map[int]time.Duration
+	// merge rebuilds the file with the translated text of each entry, entries missing from translated keep their text
+	merge func(translated map[int]string) string
+}
+
+// assLines pulls the text out of sanitized ASS dialogue. Override blocks at the start and the end of a line
+// are kept around the translation, blocks in the middle of a line are sent as numbered placeholders and put
+// back where the translation has them.
+func assLines(headers, dialogue string) (*subtitleLines, error) {
+	text, start, end := -1, -1, -1
+	for _, line := range strings.Split(headers, "\n") {
+		if isFormatLine(line) {
+			text, start, end = findField(line, "text"), findField(line, "start"), findField(line, "end")
+		}
+	}
+	if text < 0 {
+		return nil, fmt.Errorf("no events format line found")
+	}
+	type assLine struct {
+		prefix, leading, trailing, body string
+		blocks                          []string
+	}
+	lines := strings.Split(dialogue, "\n")
+	parsed := make([]assLine, len(lines))
+	sl := &subtitleLines{durations: make(map[int]time.Duration)}
+	for i, line := range lines {
+		fields := strings.SplitN(line, ",", text+1)
+		if len(fields) <= text {
+			parsed[i] = assLine{prefix: line}
+			continue
+		}
+		body := fields[text]
+		l := assLine{prefix: strings.Join(fields[:text], ",") + ",", body: body}
+		if start >= 0 && end >= 0 {
+			from, err1 := parseAssTime(extractDialogueField(line, start, false))
+			to, err2 := parseAssTime(extractDialogueField(line, end, false))
+			if err1 == nil && err2 == nil {
+				sl.durations[i] = to - from
+			}
+		}
+		l.leading = leadingBlocksRegex.FindString(body)
+		body = strings.TrimPrefix(body, l.leading)
+		l.trailing = trailingBlocksRegex.FindString(body)
+		body = strings.TrimSuffix(body, l.trailing)
+		body = overrideBlockRegex.ReplaceAllStringFunc(body, func(block string) string {
+			l.blocks = append(l.blocks, block)
+			return fmt.Sprintf("{%d}", len(l.blocks))
+		})
+		parsed[i] = l
+		sl.entries = append(sl.entries, lineEntry{Id: i, Text: strings.ReplaceAll(body, `\N`, "\n")})
+	}
+	sl.merge = func(translated map[int]string) string {
+		out := make([]string, len(parsed))
+		for i, l := range parsed {
+			t, ok := translated[i]
+			if !ok {
+				out[i] = l.prefix + l.body
+				continue
+			}
+			t = placeholderRegex.ReplaceAllStringFunc(t, func(placeholder string) string {
+				n, _ := strconv.Atoi(placeholderRegex.FindStringSubmatch(placeholder)[1])
+				if n < 1 || n > len(l.blocks) {
+					return ""
+				}
+				return l.blocks[n-1]
+			})
+			out[i] = l.prefix + l.leading + strings.ReplaceAll(t, "\n", `\N`) + l.trailing
+		}
+		return sanitizeOutputASS(headers, strings.Join(out, "\n"))
+	}
+	return sl, nil
+}
+
+// vttLines pulls the text of every cue out of sanitized WebVTT, the cue timing lines are kept as they are
+func vttLines(input string) *subtitleLines {
+	cues := vttBlocks(input)
+	sl := &subtitleLines{durations: make(map[int]time.Duration)}
+	for i, c := range cues {
+		sl.entries = append(sl.entries, lineEntry{Id: i, Text: c.text})
+		if fields := strings.Fields(c.timing); len(fields) >= 3 {
+			from, err1 := parseVTTTime(fields[0])
+			to, err2 := parseVTTTime(fields[2])
+			if err1 == nil && err2 == nil {
+				sl.durations[i] = to - from
+			}
+		}
+	}
+	sl.merge = func(translated map[int]string) string {
+		var sb strings.Builder
+		sb.WriteString("WEBVTT\n\n")
+		for i, c := range cues {
+			t, ok := translated[i]
+			if !ok {
+				t = c.text
+			}
+			sb.WriteString(c.timing + "\n" + t + "\n\n")
+		}
+		return sb.String()
+	}
+	return sl
+}
+
+// vttBlock is a cue of sanitized WebVTT, timing is the whole timing line including cue settings
+type vttBlock struct {
+	timing string
+	text   string
+}
+
+func vttBlocks(input string) []vttBlock {
+	var cues []vttBlock
+	for _, line := range strings.Split(input, "\n") {
+		if utils.IsWebVTTTimeRangeLine(line) {
+			cues = append(cues, vttBlock{timing: line})
+		} else if len(cues) > 0 && strings.TrimSpace(line) != "" {
+			if cues[len(cues)-1].text != "" {
+				cues[len(cues)-1].text += "\n"
+			}
+			cues[len(cues)-1].text += line
+		}
+	}
+	return cues
+}
+
+// batchLines groups entries into JSON requests of roughly batchLength characters
+func batchLines(entries []lineEntry, batchLength int) ([]string, error) {
+	var batches []string
+	var current []lineEntry
+	count := 0
+	flush := func() error {
+		if len(current) == 0 {
+			return nil
+		}
+		b, err := json.Marshal(lineBatch{Lines: current})
+		if err != nil {
+			return err
+		}
+		batches = append(batches, string(b))
+		current = nil
+		count = 0
+		return nil
+	}
+	for _, e := range entries {
+		current = append(current, e)
+		count += len(e.Text)
+		if count >= batchLength {
+			if err := flush(); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return batches, flush()
+}
+
+func parseLineBatch(text string) (*lineBatch, error) {
+	text = strings.TrimSpace(text)
+	text = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(text, "```json"), "```"), "```")
+	batch := &lineBatch{}
+	err := json.Unmarshal([]byte(text), batch)
+	return batch, err
+}
+
+// collectLines records the translations of the requested ids, ids the model made up are reported and dropped
+func collectLines(input, output string, translated map[int]string) {
+	requested, err := parseLineBatch(input)
+	if err != nil {
+		discord.Errorf("error parsing request: %v", err)
+		return
+	}
+	result, err := parseLineBatch(output)
+	if err != nil {
+		discord.Errorf("error parsing translated lines: %v", err)
+		return
+	}
+	ids := make([]int, 0, len(requested.Lines))
+	for _, l := range requested.Lines {
+		ids = append(ids, l.Id)
+	}
+	for _, l := range result.Lines {
+		if !slices.Contains(ids, l.Id) {
+			discord.Errorf("Dropping line %d, it wasn't requested", l.Id)
+			continue
+		}
+		translated[l.Id] = l.Text
+	}
+}
+
+// matchedShare is the share of requested ids found in a response
+func matchedShare(input string, result ai.Result) float64 {
+	requested, err := parseLineBatch(input)
+	if err != nil || len(requested.Lines) == 0 {
+		return 0
+	}
+	output, err := parseLineBatch(result.Text())
+	if err != nil {
+		discord.Errorf("Invalid JSON in response: %v", err)
+		return 0
+	}
+	returned := make(map[int]bool)
+	for _, l := range output.Lines {
+		returned[l.Id] = true
+	}
+	matched := 0
+	for _, l := range requested.Lines {
+		if returned[l.Id] {
+			matched++
+		}
+	}
+	discord.Infof("Requested lines: %d, returned lines: %d, matched: %d", len(requested.Lines), len(output.Lines), matched)
+	return float64(matched) / float64(len(requested.Lines))
+}
+
+// sendLines translates JSON batches and adds the results to translated
+func sendLines(ctx context.Context, inputs []string, systemMessage string, cutoff float64, translated map[int]string,
+	opts ...ai.ChatOption) error {
+	for len(inputs) > 0 {
+		n := min(len(inputs), maxSegments)
+		results, err := ai.SendWithRetrySplit(ctx, systemMessage, inputs[:n], func(input string, result ai.Result) bool {
+			return matchedShare(input, result) >= cutoff
+		}, func(input string) int {
+			batch, _ := parseLineBatch(input)
+			return len(batch.Lines)
+		}, func(input string) string {
+			return input
+		}, append(slices.Clone(opts), ai.WithJSONSchema("subtitle_lines", linesSchema))...)
+		if err != nil {
+			return err
+		}
+		for i, result := range results {
+			collectLines(inputs[i], result, translated)
+		}
+		inputs = inputs[n:]
+	}
+	return nil
+}
+
+// abortError returns the error a pass tolerating failures still has to give up on: a cancelled context or
+// every AI provider out of quota, the translation is deferred then instead of written half done
+func abortError(ctx context.Context, err error) error {
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	var exhausted *ai.ExhaustedError
+	if errors.As(err, &exhausted) {
+		return err
+	}
+	return nil
+}
+
+// resendLines requests entries again one by one, a line failing again is reported and left out of translated
+func resendLines(ctx context.Context, entries []lineEntry, systemMessage string, translated map[int]string,
+	opts ...ai.ChatOption) error {
+	for _, e := range entries {
+		single, err := batchLines([]lineEntry{e}, 0)
+		if err != nil {
+			return err
+		}
+		err = sendLines(ctx, single, systemMessage, 1, translated, opts...)
+		if err != nil {
+			if abort := abortError(ctx, err); abort != nil {
+				return abort
+			}
+			discord.Errorf("error translating line %d again: %v", e.Id, err)
+		}
+	}
+	return nil
+}
+
+// TranslateSubtitleLines translates the entries of a subtitle file and merges them back, lines missing
+// from a response are requested again one by one and keep their original text if that fails too.
+// Lines failing check, when given, are requested again once as well. Lines found in mem, when given,
+// aren't sent at all and the new translations are added to it.
+func TranslateSubtitleLines(ctx context.Context, sl *subtitleLines, language, systemMessage string,
+	check func(source, translated string) bool, mem *memory, opts ...ai.ChatOption) (string, error) {
+	translated := make(map[int]string)
+	var pending []lineEntry
+	for _, e := range sl.entries {
+		if t, ok := mem.lookup(e.Text); ok {
+			translated[e.Id] = t
+			continue
+		}
+		pending = append(pending, e)
+	}
+	discord.Infof("[LINES] Translating %d lines to language: %s, %d found in memory",
+		len(pending), language, len(sl.entries)-len(pending))
+	// every batch goes to the memory as soon as it is translated, lines failing check only once their retry passes
+	checkOpts := opts
+	opts = append(slices.Clone(opts), ai.WithProgress(func(input, output string) {
+		mem.rememberBatch(input, output, check)
+	}))
+	batches, err := batchLines(pending, config.TheConfig.TranslationBatchLength)
+	if err != nil {
+		return "", err
+	}
+	err = sendLines(ctx, batches, systemMessage, config.TheConfig.TranslationOutputCutoff, translated, opts...)
+	if err != nil {
+		return "", err
+	}
+
+	var missing []lineEntry
+	for _, e := range sl.entries {
+		if _, ok := translated[e.Id]; !ok {
+			missing = append(missing, e)
+		}
+	}
+	if len(missing) > 0 {
+		discord.Infof("Requesting %d missing lines one by one", len(missing))
+		err = resendLines(ctx, missing, systemMessage, translated, opts...)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if check != nil {
+		var failing []lineEntry
+		for _, e := range sl.entries {
+			if t, ok := translated[e.Id]; ok && !check(e.Text, t) {
+				failing = append(failing, e)
+			}
+		}
+		if len(failing) > 0 {
+			discord.Infof("Requesting %d lines failing the check again", len(failing))
+			retried := make(map[int]string)
+			err = resendLines(ctx, failing, systemMessage, retried, checkOpts...)
+			if err != nil {
+				return "", err
+			}
+			remembered := make(map[string]string)
+			for _, e := range failing {
+				if t, ok := retried[e.Id]; ok && check(e.Text, t) {
+					translated[e.Id] = t
+					remembered[e.Text] = t
+				}
+			}
+			if err = mem.remember(remembered); err != nil {
+				discord.Errorf("Error saving translation memory: %v", err)
+			}
+		}
+	}
+
+	for _, e := range sl.entries {
+		if _, ok := translated[e.Id]; !ok {
+			discord.Errorf("Line %d was never translated, keeping the original: %s", e.Id, e.Text)
+			translated[e.Id] = e.Text
+		}
+	}
+	return sl.merge(translated), nil
+}
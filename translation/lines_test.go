@@ -0,0 +1,41 @@
+package translation
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAssLinesMergeKeepsTimingAndTags(t *testing.T) {
+	headers := "[Events]\nFormat: Layer, Start, End, Style, Name, MarginL, MarginR, MarginV, Effect, Text"
+	dialogue := "Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,{\\an8}Hello,{\\i1} you{\\i0}\\Nthere{\\fad(0,200)}\n" +
+		"Dialogue: 0,0:00:04.00,0:00:05.00,Default,,0,0,0,,Bye"
+	sl, err := assLines(headers, dialogue)
+	if err != nil {
+		t.Fatalf("Failed to split lines: %v", err)
+	}
+	if len(sl.entries) != 2 || sl.entries[0].Text != "Hello,{1} you{2}\nthere" {
+		t.Fatalf("Unexpected entries: %+v", sl.entries)
+	}
+	// line 1 is missing from the translation and keeps its original text instead of shifting,
+	// placeholders the translation made up are dropped
+	merged := sl.merge(map[int]string{0: "Hallo,{1} du{2}{3}\nda"})
+	want := "Dialogue: 0,0:00:01.00,0:00:03.00,Default,,0,0,0,,{\\an8}Hallo,{\\i1} du{\\i0}\\Nda{\\fad(0,200)}"
+	if !strings.Contains(merged, want) {
+		t.Errorf("Merged output is missing %q:\n%s", want, merged)
+	}
+	if !strings.Contains(merged, "Dialogue: 0,0:00:04.00,0:00:05.00,Default,,0,0,0,,Bye") {
+		t.Errorf("Untranslated line lost its timing:\n%s", merged)
+	}
+}
+
+func TestVttLinesMerge(t *testing.T) {
+	sl := vttLines("WEBVTT\n00:00:01.000 --> 00:00:02.000\nOne\ntwo\n\n00:00:03.000 --> 00:00:04.000 line:0\nThree")
+	if len(sl.entries) != 2 || sl.entries[0].Text != "One\ntwo" {
+		t.Fatalf("Unexpected entries: %+v", sl.entries)
+	}
+	merged := sl.merge(map[int]string{0: "Eins\nzwei", 1: "Drei"})
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000\nEins\nzwei\n\n00:00:03.000 --> 00:00:04.000 line:0\nDrei\n\n"
+	if merged != want {
+		t.Errorf("Unexpected output:\n%s", merged)
+	}
+}
@@ -0,0 +1,202 @@
+package translation
+
+import (
+	"Sparkle/config"
+	"Sparkle/discord"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+const memoryDir = "memory"
+
+type memoryEntry struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Stored int64  `json:"stored,omitempty"` // unix time, the oldest entries go first over the limit
+}
+
+// memory is the translation memory of one language pair, entries are keyed by the hash of the
+// normalized source text and stored in DataDir/memory/<source>-<target>.json
+type memory struct {
+	path    string
+	entries map[string]memoryEntry
+}
+
+var memoryMutex sync.Mutex
+
+// normalizeLine collapses whitespace so the same line with different spacing or line breaks matches
+func normalizeLine(text string) string {
+	return strings.Join(strings.Fields(text), " ")
+}
+
+func hashKey(text string) string {
+	sum := sha256.Sum256([]byte(text))
+	return hex.EncodeToString(sum[:])
+}
+
+func memoryKey(text string) string {
+	return hashKey(normalizeLine(text))
+}
+
+func loadMemory(sourceLanguage, targetLanguage string) (*memory, error) {
+	m := &memory{
+		path: filepath.Join(config.TheConfig.DataDir, memoryDir,
+			strings.ToLower(sourceLanguage)+"-"+strings.ToLower(targetLanguage)+".json"),
+		entries: make(map[string]memoryEntry),
+	}
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	content, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, &m.entries)
+	return m, err
+}
+
+// lookup returns the remembered translation of text, a nil memory remembers nothing
+func (m *memory) lookup(text string) (string, bool) {
+	if m == nil || normalizeLine(text) == "" {
+		return "", false
+	}
+	e, ok := m.entries[memoryKey(text)]
+	return e.Target, ok
+}
+
+// remember adds translations to the memory and writes it back, entries other jobs stored in the meantime are kept
+// and the oldest ones dropped over config.TranslationMemoryLimit
+func (m *memory) remember(translations map[string]string) error {
+	if m == nil {
+		return nil
+	}
+	now := time.Now().Unix()
+	for source, target := range translations {
+		if normalizeLine(source) == "" {
+			continue
+		}
+		m.entries[memoryKey(source)] = memoryEntry{Source: source, Target: target, Stored: now}
+	}
+	memoryMutex.Lock()
+	defer memoryMutex.Unlock()
+	stored := make(map[string]memoryEntry)
+	content, err := os.ReadFile(m.path)
+	if err == nil {
+		err = json.Unmarshal(content, &stored)
+	}
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	for k, e := range m.entries {
+		if old, ok := stored[k]; !ok || old.Stored <= e.Stored {
+			stored[k] = e
+		}
+	}
+	if limit := config.TheConfig.TranslationMemoryLimit; limit > 0 && len(stored) > limit {
+		keys := slices.SortedFunc(maps.Keys(stored), func(a, b string) int {
+			return cmp.Compare(stored[a].Stored, stored[b].Stored)
+		})
+		for _, k := range keys[:len(keys)-limit] {
+			delete(stored, k)
+		}
+	}
+	m.entries = stored
+	err = os.MkdirAll(filepath.Dir(m.path), 0755)
+	if err != nil {
+		return err
+	}
+	content, err = json.Marshal(m.entries)
+	if err != nil {
+		return err
+	}
+	// written through a temporary file so a crash never leaves a truncated memory behind
+	tmp := m.path + ".tmp"
+	if err = os.WriteFile(tmp, content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, m.path)
+}
+
+// rememberBatch remembers the lines of a translated JSON batch that pass check, when given. It is given to
+// ai.WithProgress so a crash only loses the batches still in flight.
+func (m *memory) rememberBatch(input, output string, check func(source, translated string) bool) {
+	if m == nil {
+		return
+	}
+	requested, err := parseLineBatch(input)
+	if err != nil {
+		return
+	}
+	result, err := parseLineBatch(output)
+	if err != nil {
+		return
+	}
+	sources := make(map[int]string, len(requested.Lines))
+	for _, l := range requested.Lines {
+		sources[l.Id] = l.Text
+	}
+	translations := make(map[string]string, len(result.Lines))
+	for _, l := range result.Lines {
+		if source, ok := sources[l.Id]; ok && (check == nil || check(source, l.Text)) {
+			translations[source] = l.Text
+		}
+	}
+	if err = m.remember(translations); err != nil {
+		discord.Errorf("Error saving translation memory: %v", err)
+	}
+}
+
+// fileCheckpoint keeps the outputs of completed batches next to the subtitle being translated,
+// it is removed once the translation is written
+type fileCheckpoint struct {
+	path    string
+	mutex   sync.Mutex
+	outputs map[string]string
+}
+
+func newFileCheckpoint(dest string) *fileCheckpoint {
+	cp := &fileCheckpoint{path: dest + ".checkpoint.json", outputs: make(map[string]string)}
+	content, err := os.ReadFile(cp.path)
+	if err == nil && json.Unmarshal(content, &cp.outputs) != nil {
+		cp.outputs = make(map[string]string)
+	}
+	return cp
+}
+
+func (cp *fileCheckpoint) Load(input string) (string, bool) {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	output, ok := cp.outputs[hashKey(input)]
+	return output, ok
+}
+
+func (cp *fileCheckpoint) Save(input, output string) error {
+	cp.mutex.Lock()
+	defer cp.mutex.Unlock()
+	cp.outputs[hashKey(input)] = output
+	content, err := json.Marshal(cp.outputs)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(cp.path, content, 0644)
+}
+
+func (cp *fileCheckpoint) Remove() error {
+	err := os.Remove(cp.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,94 @@
+package translation
+
+import (
+	"Sparkle/config"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestMemoryRoundTrip(t *testing.T) {
+	config.TheConfig.DataDir = t.TempDir()
+	var none *memory
+	if _, ok := none.lookup("Hello"); ok {
+		t.Fatalf("A nil memory found a line")
+	}
+	if err := none.remember(map[string]string{"Hello": "Hallo"}); err != nil {
+		t.Fatalf("A nil memory failed to remember: %v", err)
+	}
+
+	m, err := loadMemory("ENG", "deu")
+	if err != nil {
+		t.Fatalf("Error loading memory: %v", err)
+	}
+	if err = m.remember(map[string]string{"Hello  there\nfriend": "Hallo Freund", "   ": "ignored"}); err != nil {
+		t.Fatalf("Error remembering: %v", err)
+	}
+	other, _ := loadMemory("eng", "deu")
+	if err = other.remember(map[string]string{"Goodbye": "Tschüss"}); err != nil {
+		t.Fatalf("Error remembering: %v", err)
+	}
+	m.rememberBatch(`{"lines":[{"id":1,"text":"Yes"},{"id":2,"text":"No"}]}`,
+		`{"lines":[{"id":1,"text":"Ja"},{"id":3,"text":"Vielleicht"}]}`, nil)
+	m.rememberBatch(`{"lines":[{"id":1,"text":"Stop"},{"id":2,"text":"Go"}]}`,
+		`{"lines":[{"id":1,"text":"Halt"},{"id":2,"text":"Go"}]}`, func(source, translated string) bool {
+			return source != translated
+		})
+
+	reloaded, err := loadMemory("eng", "deu")
+	if err != nil {
+		t.Fatalf("Error reloading memory: %v", err)
+	}
+	for source, expected := range map[string]string{"Hello there friend": "Hallo Freund", "Goodbye": "Tschüss", "Yes": "Ja",
+		"Stop": "Halt"} {
+		if got, ok := reloaded.lookup(source); !ok || got != expected {
+			t.Errorf("Expected %q for %q, got %q", expected, source, got)
+		}
+	}
+	for _, source := range []string{"No", "   ", "Go"} {
+		if _, ok := reloaded.lookup(source); ok {
+			t.Errorf("Unexpected memory entry for %q", source)
+		}
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	config.TheConfig.DataDir = t.TempDir()
+	config.TheConfig.TranslationMemoryLimit = 2
+	defer func() { config.TheConfig.TranslationMemoryLimit = 0 }()
+	m, _ := loadMemory("eng", "deu")
+	m.entries[memoryKey("One")] = memoryEntry{Source: "One", Target: "Eins", Stored: 1}
+	if err := m.remember(map[string]string{"Two": "Zwei", "Three": "Drei"}); err != nil {
+		t.Fatalf("Error remembering: %v", err)
+	}
+	reloaded, _ := loadMemory("eng", "deu")
+	if _, ok := reloaded.lookup("One"); ok || len(reloaded.entries) != 2 {
+		t.Errorf("Expected the oldest entry to be dropped, got %+v", reloaded.entries)
+	}
+	if _, err := os.Stat(m.path + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temporary file left behind: %v", err)
+	}
+}
+
+func TestFileCheckpoint(t *testing.T) {
+	dest := filepath.Join(t.TempDir(), "1-deu.ass")
+	cp := newFileCheckpoint(dest)
+	if _, ok := cp.Load("batch"); ok {
+		t.Fatalf("An empty checkpoint loaded a batch")
+	}
+	if err := cp.Save("batch", "translated"); err != nil {
+		t.Fatalf("Error saving checkpoint: %v", err)
+	}
+	if output, ok := newFileCheckpoint(dest).Load("batch"); !ok || output != "translated" {
+		t.Errorf("Unexpected output after reload: %q", output)
+	}
+	if err := cp.Remove(); err != nil {
+		t.Fatalf("Error removing checkpoint: %v", err)
+	}
+	if _, err := os.Stat(dest + ".checkpoint.json"); !os.IsNotExist(err) {
+		t.Errorf("Checkpoint file still exists: %v", err)
+	}
+	if err := cp.Remove(); err != nil {
+		t.Errorf("Removing a missing checkpoint failed: %v", err)
+	}
+}
@@ -0,0 +1,213 @@
+package translation
+
+import (
+	"Sparkle/ai"
+	"Sparkle/config"
+	"Sparkle/discord"
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// qualityReportSuffix is appended to the path of a translation for its quality report
+const qualityReportSuffix = ".quality.json"
+
+// ReadingLimits are the reading speed and line length a translated cue has to keep to
+type ReadingLimits struct {
+	MaxCps        float64 `json:"max_cps"`
+	MaxLineLength int     `json:"max_line_length"`
+}
+
+// CueIssue is a cue over the reading limits
+type CueIssue struct {
+	Id         int     `json:"id"`
+	Text       string  `json:"text"`
+	Duration   float64 `json:"duration"` // seconds
+	Cps        float64 `json:"cps"`
+	LineLength int     `json:"line_length"`
+}
+
+// QualityReport lists the cues of a translation still over the reading limits after shortening
+type QualityReport struct {
+	File      string        `json:"file"`
+	Language  string        `json:"language"`
+	Limits    ReadingLimits `json:"limits"`
+	Cues      int           `json:"cues"`
+	Shortened int           `json:"shortened"`
+	Failing   []CueIssue    `json:"failing"`
+}
+
+type shortenEntry struct {
+	Id            int    `json:"id"`
+	Text          string `json:"text"`
+	MaxCharacters int    `json:"max_characters"`
+}
+
+type shortenBatch struct {
+	Lines []shortenEntry `json:"lines"`
+}
+
+// readingLimits returns the limits configured for a language code, entries are code:cps:length and * is the fallback
+func readingLimits(languageCode string) ReadingLimits {
+	limits := ReadingLimits{MaxCps: 20, MaxLineLength: 42}
+	for _, code := range []string{"*", strings.ToLower(languageCode)} {
+		for _, entry := range config.TheConfig.ReadingLimits {
+			parts := strings.Split(strings.TrimSpace(entry), ":")
+			if len(parts) != 3 || strings.ToLower(parts[0]) != code {
+				continue
+			}
+			cps, err1 := strconv.ParseFloat(parts[1], 64)
+			length, err2 := strconv.Atoi(parts[2])
+			if err1 != nil || err2 != nil {
+				discord.Errorf("Invalid reading limit: %s", entry)
+				continue
+			}
+			limits = ReadingLimits{MaxCps: cps, MaxLineLength: length}
+		}
+	}
+	return limits
+}
+
+// measureCue returns the characters per second and the longest row of a cue, line breaks and tags don't count
+func measureCue(text string, duration time.Duration) (float64, int) {
+	text = placeholderRegex.ReplaceAllString(vttTagRegex.ReplaceAllString(text, ""), "")
+	longest := 0
+	for _, row := range strings.Split(text, "\n") {
+		longest = max(longest, utf8.RuneCountInString(row))
+	}
+	if duration <= 0 {
+		return 0, longest
+	}
+	return float64(utf8.RuneCountInString(strings.ReplaceAll(text, "\n", ""))) / duration.Seconds(), longest
+}
+
+// issues lists the entries of sl over the limits, entries without a timing are only checked for line length
+func (l ReadingLimits) issues(sl *subtitleLines) []CueIssue {
+	var issues []CueIssue
+	for _, e := range sl.entries {
+		duration := sl.durations[e.Id]
+		cps, length := measureCue(e.Text, duration)
+		if cps > l.MaxCps || length > l.MaxLineLength {
+			issues = append(issues, CueIssue{
+				Id:         e.Id,
+				Text:       e.Text,
+				Duration:   duration.Seconds(),
+				Cps:        math.Round(cps*10) / 10,
+				LineLength: length,
+			})
+		}
+	}
+	return issues
+}
+
+// maxCharacters is the budget of a cue, what it can show in its duration but never more than two full rows
+func (l ReadingLimits) maxCharacters(duration float64) int {
+	budget := 2 * l.MaxLineLength
+	if duration > 0 {
+		budget = min(budget, int(l.MaxCps*duration))
+	}
+	return max(budget, 1)
+}
+
+func parseTranslated(subtitleSuffix, translated string) (*subtitleLines, error) {
+	if subtitleSuffix == "ass" {
+		headers, dialogue, err := sanitizeInputASS(translated)
+		if err != nil {
+			return nil, err
+		}
+		return assLines(headers, dialogue)
+	}
+	return vttLines(translated), nil
+}
+
+// shortenBatches groups the cues to shorten into JSON requests of roughly batchLength characters
+func shortenBatches(issues []CueIssue, limits ReadingLimits, batchLength int) ([]string, error) {
+	var batches []string
+	var current shortenBatch
+	count := 0
+	for i, issue := range issues {
+		current.Lines = append(current.Lines, shortenEntry{
+			Id:            issue.Id,
+			Text:          issue.Text,
+			MaxCharacters: limits.maxCharacters(issue.Duration),
+		})
+		count += len(issue.Text)
+		if count >= batchLength || i == len(issues)-1 {
+			b, err := json.Marshal(current)
+			if err != nil {
+				return nil, err
+			}
+			batches = append(batches, string(b))
+			current = shortenBatch{}
+			count = 0
+		}
+	}
+	return batches, nil
+}
+
+// checkReadingSpeed measures every cue of a translation against the limits of its language. Cues over them
+// are sent back with the shorten instruction, a shorter answer replaces the cue. What still fails is written
+// to the quality report next to dest.
+func checkReadingSpeed(ctx context.Context, dest, subtitleSuffix, translated, languageCode, systemMessage string,
+	opts ...ai.ChatOption) (string, error) {
+	limits := readingLimits(languageCode)
+	sl, err := parseTranslated(subtitleSuffix, translated)
+	if err != nil {
+		return translated, err
+	}
+	report := QualityReport{File: filepath.Base(dest), Language: languageCode, Limits: limits, Cues: len(sl.entries)}
+	issues := limits.issues(sl)
+	if len(issues) > 0 {
+		discord.Infof("[QUALITY] %d of %d cues over %.0f cps or %d characters a line, shortening",
+			len(issues), len(sl.entries), limits.MaxCps, limits.MaxLineLength)
+		batches, err := shortenBatches(issues, limits, config.TheConfig.TranslationBatchLength)
+		if err != nil {
+			return translated, err
+		}
+		shortened := make(map[int]string)
+		err = sendLines(ctx, batches, systemMessage, config.TheConfig.TranslationOutputCutoff, shortened, opts...)
+		if err != nil {
+			if abort := abortError(ctx, err); abort != nil {
+				return translated, abort
+			}
+			discord.Errorf("Error shortening lines: %v", err)
+		}
+		accepted := make(map[int]string)
+		for _, issue := range issues {
+			t, ok := shortened[issue.Id]
+			if ok && strings.TrimSpace(t) != "" &&
+				utf8.RuneCountInString(t) < utf8.RuneCountInString(issue.Text) {
+				accepted[issue.Id] = t
+			}
+		}
+		report.Shortened = len(accepted)
+		if len(accepted) > 0 {
+			translated = sl.merge(accepted)
+			sl, err = parseTranslated(subtitleSuffix, translated)
+			if err != nil {
+				return translated, err
+			}
+			issues = limits.issues(sl)
+		}
+	}
+	report.Failing = issues
+	if len(issues) > 0 {
+		discord.Errorf("%d cues of %s are still over the reading limits", len(issues), dest)
+	}
+	content, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return translated, err
+	}
+	err = os.WriteFile(dest+qualityReportSuffix, content, 0644)
+	if err != nil {
+		return translated, fmt.Errorf("error writing quality report: %w", err)
+	}
+	return translated, nil
+}
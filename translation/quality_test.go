@@ -0,0 +1,24 @@
+package translation
+
+import (
+	"Sparkle/config"
+	"testing"
+)
+
+func TestReadingLimitIssues(t *testing.T) {
+	config.TheConfig.ReadingLimits = []string{"*:20:42", "tur:17:30"}
+	limits := readingLimits("TUR")
+	if limits != (ReadingLimits{MaxCps: 17, MaxLineLength: 30}) {
+		t.Fatalf("Unexpected limits: %+v", limits)
+	}
+	sl := vttLines("WEBVTT\n00:00:01.000 --> 00:00:03.000\nShort enough\n\n" +
+		"00:00:04.000 --> 00:00:05.000\n<i>Far too much text for one second</i>\n\n" +
+		"00:00:06.000 --> 00:00:16.000\nThis row is longer than thirty characters")
+	issues := limits.issues(sl)
+	if len(issues) != 2 || issues[0].Id != 1 || issues[0].Cps != 32 || issues[1].Id != 2 || issues[1].LineLength != 41 {
+		t.Errorf("Unexpected issues: %+v", issues)
+	}
+	if budget := limits.maxCharacters(issues[0].Duration); budget != 17 {
+		t.Errorf("Unexpected budget: %d", budget)
+	}
+}
@@ -184,7 +184,6 @@ func sanitizeInputVTT(input string) string {
 	return "WEBVTT\n" + sanitizeBlocks(sanitizeBlocks(input, true), false)
 }
 
-// TODO: context aware seasons
 // TODO: directly convert sup subtitles
 
 // sanitizeBlocks removes contiguous duplicate blocks and empty blocks from text.
@@ -275,7 +274,7 @@ func sanitizeBlocks(input string, contiguousOnly bool) string {
 						// same content, and last end time = curr start time
 						for j := len(resultLines) - 1; j >= 0; j-- {
 							if utils.IsWebVTTTimeRangeLine(resultLines[j]) { // find the lastBlock in result lines
-								resultLines[j] = lastTimeStart + " --> " + currTimeEnd
+								resultLines[j] = lastTimeStart + " --> " + currTimeEnd + last[4] // keeps the cue settings
 								lastNormalizedBlock = resultLines[j] + "\n" + strings.Join(strings.Split(lastNormalizedBlock, "\n")[1:], "\n")
 								break
 							}
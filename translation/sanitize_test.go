@@ -71,3 +71,13 @@ func ProcessFiles(dir string) error {
 
 	return nil
 }
+
+func TestMergedCuesKeepSettings(t *testing.T) {
+	input := "WEBVTT\n\n00:00:01.000 --> 00:00:02.000 line:0 align:start\nSign\n\n" +
+		"00:00:02.000 --> 00:00:04.000 line:0 align:start\nSign\n"
+	output := sanitizeBlocks(input, false)
+	if !strings.Contains(output, "00:00:01.000 --> 00:00:04.000 line:0 align:start\nSign") ||
+		strings.Contains(output, "00:00:02.000 -->") {
+		t.Errorf("Cues weren't merged with their settings:\n%s", output)
+	}
+}
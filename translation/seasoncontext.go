@@ -0,0 +1,255 @@
+package translation
+
+import (
+	"Sparkle/ai"
+	"Sparkle/config"
+	"Sparkle/discord"
+	"Sparkle/utils"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+const (
+	contextDir         = "context"
+	contextInputLength = 60000 // characters of dialogue sent to summarize an episode
+)
+
+var seasonEpisodeRegex = regexp.MustCompile(`(?i)S(\d{2,})E(\d{2,})`)
+
+// EpisodeContext is what the model noted about one episode
+type EpisodeContext struct {
+	Episode    int      `json:"episode"`
+	Characters []string `json:"characters"`
+	Terms      []string `json:"terms"`
+	Summary    string   `json:"summary"`
+}
+
+// SeasonContext collects the episode notes of a season by episode number, episodes of a season may be
+// translated in any order. It is fed to the translation of later episodes so names and running jokes stay consistent.
+type SeasonContext struct {
+	Show     string                 `json:"show"`
+	Season   int                    `json:"season"`
+	Episodes map[int]EpisodeContext `json:"episodes"`
+}
+
+// before returns the notes of the episodes before episode, in episode order
+func (sc *SeasonContext) before(episode int) []EpisodeContext {
+	var earlier []EpisodeContext
+	for n, e := range sc.Episodes {
+		if n < episode {
+			e.Episode = n
+			earlier = append(earlier, e)
+		}
+	}
+	slices.SortFunc(earlier, func(a, b EpisodeContext) int { return a.Episode - b.Episode })
+	return earlier
+}
+
+// latestNotes returns up to limit distinct notes, those of the latest episodes first
+func latestNotes(earlier []EpisodeContext, notes func(e EpisodeContext) []string, limit int) []string {
+	var collected []string
+	for i := len(earlier) - 1; i >= 0 && len(collected) < limit; i-- {
+		for _, note := range notes(earlier[i]) {
+			if len(collected) < limit && !slices.Contains(collected, note) {
+				collected = append(collected, note)
+			}
+		}
+	}
+	return collected
+}
+
+var episodeContextSchema = map[string]interface{}{
+	"type": "object",
+	"properties": map[string]interface{}{
+		"characters": map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"terms":      map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+		"summary":    map[string]interface{}{"type": "string"},
+	},
+	"required":             []string{"characters", "terms", "summary"},
+	"additionalProperties": false,
+}
+
+var contextMutex sync.Mutex
+
+// seasonLocks holds a *sync.Mutex per show and season, see seasonContext
+var seasonLocks sync.Map
+
+// parseEpisode returns the season and episode numbers of a media file name
+func parseEpisode(media string) (int, int, bool) {
+	m := seasonEpisodeRegex.FindStringSubmatch(media)
+	if m == nil {
+		return 0, 0, false
+	}
+	season, _ := strconv.Atoi(m[1])
+	episode, _ := strconv.Atoi(m[2])
+	return season, episode, true
+}
+
+func contextPath(show string, season int) string {
+	return filepath.Join(config.TheConfig.DataDir, contextDir, show, fmt.Sprintf("S%02d.json", season))
+}
+
+func loadSeasonContext(show string, season int) (*SeasonContext, error) {
+	sc := &SeasonContext{Show: show, Season: season}
+	content, err := os.ReadFile(contextPath(show, season))
+	if errors.Is(err, os.ErrNotExist) {
+		sc.Episodes = make(map[int]EpisodeContext)
+		return sc, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(content, sc)
+	if sc.Episodes == nil {
+		sc.Episodes = make(map[int]EpisodeContext)
+	}
+	return sc, err
+}
+
+func saveSeasonContext(sc *SeasonContext) error {
+	err := os.MkdirAll(filepath.Dir(contextPath(sc.Show, sc.Season)), 0755)
+	if err != nil {
+		return err
+	}
+	content, err := json.MarshalIndent(sc, "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(contextPath(sc.Show, sc.Season), content, 0644)
+}
+
+// seasonContextPrompt returns the system message section with the notes of the episodes before media
+func seasonContextPrompt(media string) string {
+	season, episode, ok := parseEpisode(media)
+	if !config.TheConfig.EnableSeasonContext || !ok {
+		return ""
+	}
+	contextMutex.Lock()
+	sc, err := loadSeasonContext(utils.GetShowId(media), season)
+	contextMutex.Unlock()
+	if err != nil {
+		discord.Errorf("Error loading season context: %v", err)
+		return ""
+	}
+	earlier := sc.before(episode)
+	if len(earlier) == 0 {
+		return ""
+	}
+	limit := config.TheConfig.SeasonContextNotes
+	characters := latestNotes(earlier, func(e EpisodeContext) []string { return e.Characters }, limit)
+	terms := latestNotes(earlier, func(e EpisodeContext) []string { return e.Terms }, limit)
+	var sb strings.Builder
+	sb.WriteString("Context from earlier episodes of this season, keep names and terms consistent with it.\n")
+	sb.WriteString("Characters: " + strings.Join(characters, "; ") + "\n")
+	sb.WriteString("Recurring terms: " + strings.Join(terms, "; ") + "\n")
+	sb.WriteString("Previously:\n")
+	for _, e := range earlier[max(0, len(earlier)-config.TheConfig.SeasonContextEpisodes):] {
+		sb.WriteString(fmt.Sprintf("- Episode %d: %s\n", e.Episode, e.Summary))
+	}
+	return strings.TrimSuffix(sb.String(), "\n")
+}
+
+// seasonContext records the notes of media and returns the prompt with those of the episodes before it. The notes
+// are recorded before translating and one episode of a season at a time, so the next episode translated alongside
+// still gets them. Running out of AI quota defers the translation, other failures only cost the notes.
+func seasonContext(ctx context.Context, media string, dialogue string) (string, error) {
+	season, _, ok := parseEpisode(media)
+	if !config.TheConfig.EnableSeasonContext || !ok {
+		return "", nil
+	}
+	lock, _ := seasonLocks.LoadOrStore(fmt.Sprintf("%s/%d", utils.GetShowId(media), season), &sync.Mutex{})
+	lock.(*sync.Mutex).Lock()
+	defer lock.(*sync.Mutex).Unlock()
+	err := recordEpisodeContext(ctx, media, dialogue)
+	if err != nil {
+		if abort := abortError(ctx, err); abort != nil {
+			return "", abort
+		}
+		discord.Errorf("Error recording episode context: %v", err)
+	}
+	return seasonContextPrompt(media), nil
+}
+
+// recordEpisodeContext asks the model for notes on an episode and stores them with its season,
+// episodes that already have notes are left alone so every translation language shares them.
+func recordEpisodeContext(ctx context.Context, media string, dialogue string) error {
+	season, episode, ok := parseEpisode(media)
+	if !config.TheConfig.EnableSeasonContext || !ok {
+		return nil
+	}
+	show := utils.GetShowId(media)
+	contextMutex.Lock()
+	sc, err := loadSeasonContext(show, season)
+	contextMutex.Unlock()
+	if err != nil {
+		return err
+	}
+	if _, ok := sc.Episodes[episode]; ok {
+		return nil
+	}
+	if len(dialogue) > contextInputLength {
+		// cut on a rune boundary, CJK dialogue is mostly multi-byte
+		cut := contextInputLength
+		for cut > 0 && !utf8.RuneStart(dialogue[cut]) {
+			cut--
+		}
+		dialogue = dialogue[:cut]
+	}
+	discord.Infof("Recording context of %s episode %d", show, episode)
+	results, err := ai.SendWithRetrySplit(ctx, config.GetContextSystemMessage(media), []string{dialogue},
+		func(input string, result ai.Result) bool {
+			return json.Unmarshal([]byte(result.Text()), &EpisodeContext{}) == nil
+		}, func(input string) int {
+			return len(strings.Split(input, "\n"))
+		}, func(input string) string {
+			return input
+		}, ai.WithJSONSchema("episode_context", episodeContextSchema))
+	if err != nil {
+		return err
+	}
+	e := EpisodeContext{}
+	err = json.Unmarshal([]byte(results[0]), &e)
+	if err != nil {
+		return err
+	}
+	e.Episode = episode
+
+	contextMutex.Lock()
+	defer contextMutex.Unlock()
+	// reload, another job of the season may have finished in the meantime
+	sc, err = loadSeasonContext(show, season)
+	if err != nil {
+		return err
+	}
+	sc.Episodes[episode] = e
+	return saveSeasonContext(sc)
+}
+
+// dialogueText returns the plain dialogue of a sanitized subtitle, one line per cue
+func dialogueText(subtitleSuffix, headers, subtitles string) string {
+	var sl *subtitleLines
+	if subtitleSuffix == "ass" {
+		var err error
+		sl, err = assLines(headers, subtitles)
+		if err != nil {
+			return subtitles
+		}
+	} else {
+		sl = vttLines(subtitles)
+	}
+	lines := make([]string, 0, len(sl.entries))
+	for _, e := range sl.entries {
+		lines = append(lines, strings.ReplaceAll(placeholderRegex.ReplaceAllString(e.Text, ""), "\n", " "))
+	}
+	return strings.Join(lines, "\n")
+}
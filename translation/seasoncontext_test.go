@@ -0,0 +1,23 @@
+package translation
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestSeasonContextByEpisode(t *testing.T) {
+	// episode 3 finished before episode 1, as concurrent jobs do
+	sc := &SeasonContext{Episodes: map[int]EpisodeContext{
+		3: {Characters: []string{"Ann", "Bo"}},
+		1: {Characters: []string{"Cy", "Ann"}},
+		5: {Characters: []string{"Dee"}},
+	}}
+	earlier := sc.before(5)
+	if len(earlier) != 2 || earlier[0].Episode != 1 || earlier[1].Episode != 3 {
+		t.Fatalf("Unexpected earlier episodes: %+v", earlier)
+	}
+	notes := latestNotes(earlier, func(e EpisodeContext) []string { return e.Characters }, 2)
+	if !slices.Equal(notes, []string{"Ann", "Bo"}) {
+		t.Errorf("Unexpected notes: %v", notes)
+	}
+}
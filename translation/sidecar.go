@@ -0,0 +1,79 @@
+package translation
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// UndeterminedLanguage is the ISO 639-2 code of a sidecar whose name doesn't carry a language
+const UndeterminedLanguage = "und"
+
+// Sidecar is a subtitle file next to the media, e.g. Movie.en.srt or Movie.eng.forced.ass
+type Sidecar struct {
+	Path     string
+	Format   string // srt, ass or vtt
+	Language string // ISO 639-2/B like the language tags of extracted streams
+	Forced   bool
+}
+
+var sidecarFormats = []string{"srt", "ass", "vtt"}
+
+// sidecarLanguages maps the language tags found in sidecar names to the ISO 639-2/B codes ffprobe reports
+var sidecarLanguages = map[string]string{
+	"en": "eng", "eng": "eng", "english": "eng",
+	"ja": "jpn", "jp": "jpn", "jpn": "jpn", "japanese": "jpn",
+	"zh": "chi", "zho": "chi", "chi": "chi", "chs": "chi", "cht": "chi", "chinese": "chi",
+	"es": "spa", "spa": "spa", "spanish": "spa",
+	"fr": "fre", "fra": "fre", "fre": "fre", "french": "fre",
+	"de": "ger", "deu": "ger", "ger": "ger", "german": "ger",
+	"it": "ita", "ita": "ita", "italian": "ita",
+	"pt": "por", "por": "por", "portuguese": "por",
+	"ru": "rus", "rus": "rus", "russian": "rus",
+	"ko": "kor", "kor": "kor", "korean": "kor",
+	"tr": "tur", "tur": "tur", "turkish": "tur",
+	"ar": "ara", "ara": "ara", "arabic": "ara",
+	"nl": "dut", "nld": "dut", "dut": "dut", "dutch": "dut",
+	"pl": "pol", "pol": "pol", "polish": "pol",
+	"sv": "swe", "swe": "swe", "swedish": "swe",
+}
+
+// FindSidecars lists the subtitle files next to mediaFile that share its name, sorted by path
+func FindSidecars(mediaFile string) ([]Sidecar, error) {
+	dir := filepath.Dir(mediaFile)
+	base := strings.TrimSuffix(filepath.Base(mediaFile), filepath.Ext(mediaFile))
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	var sidecars []Sidecar
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		format := strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+		if !slices.Contains(sidecarFormats, format) {
+			continue
+		}
+		s := parseSidecarName(strings.TrimSuffix(strings.TrimPrefix(name, base+"."), filepath.Ext(name)))
+		s.Path = filepath.Join(dir, name)
+		s.Format = format
+		sidecars = append(sidecars, s)
+	}
+	return sidecars, nil
+}
+
+// parseSidecarName reads the dot separated tags between the media name and the extension
+func parseSidecarName(tags string) Sidecar {
+	s := Sidecar{Language: UndeterminedLanguage}
+	for _, tag := range strings.Split(strings.ToLower(tags), ".") {
+		if tag == "forced" {
+			s.Forced = true
+		} else if lang, ok := sidecarLanguages[tag]; ok {
+			s.Language = lang
+		}
+	}
+	return s
+}
@@ -0,0 +1,120 @@
+package translation
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srtCue is one numbered block of a SubRip file
+type srtCue struct {
+	Start time.Duration
+	End   time.Duration
+	Text  string
+}
+
+var (
+	srtTimingRegex = regexp.MustCompile(`^\s*(\d+:\d{2}:\d{2}[,.]\d{1,3})\s*-->\s*(\d+:\d{2}:\d{2}[,.]\d{1,3})`)
+	// srtTagRegex finds the tags SubRip and WebVTT don't share, <i>, <b> and <u> are kept
+	srtTagRegex = regexp.MustCompile(`</?(?:font|c|v|lang|ruby|rt|span)(?:[.\s][^>]*)?>`)
+)
+
+// ParseSRT reads the cues of a SubRip file, blocks without a timing line are skipped
+func ParseSRT(input string) []srtCue {
+	input = strings.TrimPrefix(strings.ReplaceAll(input, "\r\n", "\n"), "\ufeff")
+	var cues []srtCue
+	for _, block := range strings.Split(input, "\n\n") {
+		lines := strings.Split(strings.Trim(block, "\n"), "\n")
+		for i, line := range lines {
+			m := srtTimingRegex.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			start, err1 := parseSRTTime(m[1])
+			end, err2 := parseSRTTime(m[2])
+			if err1 == nil && err2 == nil {
+				cues = append(cues, srtCue{Start: start, End: end, Text: strings.Join(lines[i+1:], "\n")})
+			}
+			break
+		}
+	}
+	return cues
+}
+
+// WriteSRT numbers cues from 1 and writes them as SubRip
+func WriteSRT(cues []srtCue) string {
+	var sb strings.Builder
+	for i, c := range cues {
+		sb.WriteString(fmt.Sprintf("%d\n%s --> %s\n%s\n\n", i+1, formatSRTTime(c.Start), formatSRTTime(c.End), c.Text))
+	}
+	return sb.String()
+}
+
+// SrtToVTT converts SubRip to WebVTT, font tags are dropped and the text is escaped
+func SrtToVTT(input string) string {
+	var sb strings.Builder
+	sb.WriteString("WEBVTT\n\n")
+	for _, c := range ParseSRT(input) {
+		text := srtTagRegex.ReplaceAllString(c.Text, "")
+		text = strings.ReplaceAll(text, "&", "&amp;")
+		text = strings.NewReplacer("<i>", "\x00i", "</i>", "\x00/i", "<b>", "\x00b", "</b>", "\x00/b",
+			"<u>", "\x00u", "</u>", "\x00/u").Replace(text)
+		text = strings.NewReplacer("<", "&lt;", ">", "&gt;").Replace(text)
+		text = strings.NewReplacer("\x00/i", "</i>", "\x00i", "<i>", "\x00/b", "</b>", "\x00b", "<b>",
+			"\x00/u", "</u>", "\x00u", "<u>").Replace(text)
+		sb.WriteString(formatVTTTime(c.Start) + " --> " + formatVTTTime(c.End) + "\n" + text + "\n\n")
+	}
+	return sb.String()
+}
+
+// VttToSRT converts WebVTT to SubRip, cue settings, classes and voice tags are dropped
+func VttToSRT(input string) string {
+	var cues []srtCue
+	for _, b := range vttBlocks(strings.ReplaceAll(input, "\r\n", "\n")) {
+		fields := strings.Fields(b.timing)
+		if len(fields) < 3 {
+			continue
+		}
+		start, err1 := parseVTTTime(fields[0])
+		end, err2 := parseVTTTime(fields[2])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		text := srtTagRegex.ReplaceAllString(b.text, "")
+		text = strings.NewReplacer("&lt;", "<", "&gt;", ">", "&nbsp;", " ", "&amp;", "&").Replace(text)
+		cues = append(cues, srtCue{Start: start, End: end, Text: text})
+	}
+	return WriteSRT(cues)
+}
+
+// parseSRTTime parses hh:mm:ss,mmm, a dot is accepted in place of the comma
+func parseSRTTime(value string) (time.Duration, error) {
+	clock, fraction, _ := strings.Cut(strings.ReplaceAll(value, ",", "."), ".")
+	parts := strings.Split(clock, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid SRT time: %s", value)
+	}
+	var total time.Duration
+	for i, unit := range []time.Duration{time.Hour, time.Minute, time.Second} {
+		n, err := strconv.Atoi(parts[i])
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(n) * unit
+	}
+	if fraction != "" {
+		ms, err := strconv.Atoi((fraction + "00")[:3])
+		if err != nil {
+			return 0, err
+		}
+		total += time.Duration(ms) * time.Millisecond
+	}
+	return total, nil
+}
+
+func formatSRTTime(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf("%02d:%02d:%02d,%03d", ms/3600000, ms/60000%60, ms/1000%60, ms%1000)
+}
@@ -0,0 +1,48 @@
+package translation
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+const srtSample = "\ufeff1\r\n00:00:01,000 --> 00:00:02,500\r\n<i>Hello</i> & <font color=\"#ff0000\">you</font>\r\n\r\n" +
+	"2\r\n00:01:02,050 --> 00:01:03,000\r\nTwo\r\nlines\r\n"
+
+func TestSrtToVTT(t *testing.T) {
+	want := "WEBVTT\n\n00:00:01.000 --> 00:00:02.500\n<i>Hello</i> &amp; you\n\n" +
+		"00:01:02.050 --> 00:01:03.000\nTwo\nlines\n\n"
+	if vtt := SrtToVTT(srtSample); vtt != want {
+		t.Errorf("Unexpected WebVTT:\n%q", vtt)
+	}
+}
+
+func TestVttToSRT(t *testing.T) {
+	want := "1\n00:00:01,000 --> 00:00:02,500\n<i>Hello</i> & you\n\n" +
+		"2\n00:01:02,050 --> 00:01:03,000\nTwo\nlines\n\n"
+	if srt := VttToSRT(SrtToVTT(srtSample)); srt != want {
+		t.Errorf("Unexpected SubRip:\n%q", srt)
+	}
+}
+
+func TestFindSidecars(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"Movie.mkv", "Movie.en.srt", "Movie.eng.forced.ass", "Movie.srt", "Movie.nfo", "Other.en.srt"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sidecars, err := FindSidecars(filepath.Join(dir, "Movie.mkv"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Sidecar{
+		{Path: filepath.Join(dir, "Movie.en.srt"), Format: "srt", Language: "eng"},
+		{Path: filepath.Join(dir, "Movie.eng.forced.ass"), Format: "ass", Language: "eng", Forced: true},
+		{Path: filepath.Join(dir, "Movie.srt"), Format: "srt", Language: UndeterminedLanguage},
+	}
+	if !slices.Equal(sidecars, want) {
+		t.Errorf("Unexpected sidecars: %+v", sidecars)
+	}
+}
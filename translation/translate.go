@@ -4,6 +4,7 @@ import (
 	"Sparkle/ai"
 	"Sparkle/config"
 	"Sparkle/discord"
+	"Sparkle/glossary"
 	"Sparkle/utils"
 	"context"
 	"fmt"
@@ -26,10 +27,15 @@ func findInputLang(languages map[string]string) (string, string) {
 	return "", ""
 }
 
-func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffix string, convertToVTT bool) error {
+func Translate(ctx context.Context, media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffix string, convertToVTT bool) error {
 	ss := strings.Split(languageWithCode, ";")
 	language := ss[0]
 	languageCode := ss[1]
+	// SRT is translated from the WebVTT sources and written as SubRip
+	sourceSuffix := subtitleSuffix
+	if subtitleSuffix == "srt" {
+		sourceSuffix = "vtt"
+	}
 
 	stat, err := os.Stat(dest)
 	statInput, _ := os.Stat(mediaFile)
@@ -45,7 +51,7 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 	languages := make(map[string]string)
 	languageHeaders := make(map[string]string)
 	for _, file := range files {
-		if strings.HasSuffix(file.Name(), fmt.Sprintf(".%s", subtitleSuffix)) && strings.Contains(file.Name(), "-") {
+		if strings.HasSuffix(file.Name(), fmt.Sprintf(".%s", sourceSuffix)) && strings.Contains(file.Name(), "-") {
 			if len(file.Name()) >= 7 {
 				lang := strings.ToLower(file.Name()[len(file.Name())-7 : len(file.Name())-4])
 				source := filepath.Join(inputDir, file.Name())
@@ -53,6 +59,9 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 					discord.Infof("SKIPPING: Subtitle with language %s already exists: %s",
 						language,
 						dest)
+					if subtitleSuffix == "srt" {
+						return vttToSRTFile(source, dest)
+					}
 					_, err = utils.CopyFile(source, dest)
 					return err
 				}
@@ -63,9 +72,9 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 				}
 				subtitles := string(fBytes)
 				headers := ""
-				if subtitleSuffix == "vtt" {
+				if sourceSuffix == "vtt" {
 					subtitles = sanitizeInputVTT(subtitles)
-				} else if subtitleSuffix == "ass" {
+				} else if sourceSuffix == "ass" {
 					headers, subtitles, err = sanitizeInputASS(subtitles)
 					if err != nil {
 						discord.Errorf("Error sanitizing input ass: %v", err)
@@ -86,16 +95,57 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 		return fmt.Errorf("unable to find any %s subtitle", subtitleSuffix)
 	}
 	in, chosenLanguage := findInputLang(languages)
+	g, err := glossary.Load(utils.GetShowId(media))
+	if err != nil {
+		discord.Errorf("Error loading glossary: %v", err)
+		g = &glossary.Glossary{}
+	}
+	glossaryPrompt := g.Prompt(languageCode)
+	contextPrompt, err := seasonContext(ctx, media, dialogueText(sourceSuffix, languageHeaders[chosenLanguage], in))
+	if err != nil {
+		return err
+	}
+	checkpoint := newFileCheckpoint(dest)
 	var translated string
-	if subtitleSuffix == "vtt" {
-		translated, err = TranslateSubtitlesWebVTT(splitByCharacters(in, config.TheConfig.TranslationBatchLength, false),
-			language, config.GetSystemMessage(chosenLanguage, language, media, config.WEBVTT))
+	if config.TheConfig.TranslationMode == LinesMode {
+		var sl *subtitleLines
+		if sourceSuffix == "vtt" {
+			sl = vttLines(in)
+		} else if sourceSuffix == "ass" {
+			sl, err = assLines(languageHeaders[chosenLanguage], in)
+			if err != nil {
+				return err
+			}
+		} else {
+			return fmt.Errorf("unknown subtitle type: %s", subtitleSuffix)
+		}
+		var mem *memory
+		if config.TheConfig.EnableTranslationMemory {
+			mem, err = loadMemory(chosenLanguage, languageCode)
+			if err != nil {
+				discord.Errorf("Error loading translation memory: %v", err)
+				mem = nil
+			}
+		}
+		translated, err = TranslateSubtitleLines(ctx, sl, language,
+			config.GetSystemMessage(chosenLanguage, language, media, config.LINES, glossaryPrompt, contextPrompt),
+			func(source, translated string) bool {
+				return len(g.Check(languageCode, source, translated)) == 0
+			}, mem, ai.WithCheckpoint(checkpoint))
 		if err != nil {
 			return err
 		}
-	} else if subtitleSuffix == "ass" {
-		translated, err = TranslateSubtitlesASS(languageHeaders[chosenLanguage], splitByCharacters(in, config.TheConfig.TranslationBatchLength, true),
-			language, config.GetSystemMessage(chosenLanguage, language, media, config.ASS))
+	} else if sourceSuffix == "vtt" {
+		translated, err = TranslateSubtitlesWebVTT(ctx, splitByCharacters(in, config.TheConfig.TranslationBatchLength, false),
+			language, config.GetSystemMessage(chosenLanguage, language, media, config.WEBVTT, glossaryPrompt, contextPrompt),
+			ai.WithCheckpoint(checkpoint))
+		if err != nil {
+			return err
+		}
+	} else if sourceSuffix == "ass" {
+		translated, err = TranslateSubtitlesASS(ctx, languageHeaders[chosenLanguage], splitByCharacters(in, config.TheConfig.TranslationBatchLength, true),
+			language, config.GetSystemMessage(chosenLanguage, language, media, config.ASS, glossaryPrompt, contextPrompt),
+			ai.WithCheckpoint(checkpoint))
 		if err != nil {
 			return err
 		}
@@ -104,10 +154,52 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 		return fmt.Errorf("unknown subtitle type: %s", subtitleSuffix)
 	}
 
-	err = os.WriteFile(dest, []byte(translated), 0755)
+	if config.TheConfig.EnableQualityPass {
+		translated, err = checkReadingSpeed(ctx, dest, sourceSuffix, translated, languageCode,
+			config.GetShortenSystemMessage(language, media, readingLimits(languageCode).MaxLineLength, glossaryPrompt),
+			ai.WithCheckpoint(checkpoint))
+		if err != nil {
+			if abort := abortError(ctx, err); abort != nil {
+				return abort
+			}
+			discord.Errorf("Error checking reading speed: %v", err)
+		}
+	}
+
+	for _, m := range g.Check(languageCode, in, translated) {
+		discord.Errorf("Glossary mismatch in %s: %s => %s expected %d times, found %d", dest, m.Term, m.Translation, m.Expected, m.Found)
+	}
+
+	output := translated
+	if subtitleSuffix == "srt" {
+		output = VttToSRT(translated)
+	}
+	err = os.WriteFile(dest, []byte(output), 0755)
 	if err != nil {
 		return err
 	}
+	err = checkpoint.Remove()
+	if err != nil {
+		discord.Errorf("Error removing checkpoint: %v", err)
+	}
+	if config.TheConfig.EnableDualSubtitles {
+		var dual string
+		if sourceSuffix == "ass" {
+			dual, err = MergeDualASS(languageHeaders[chosenLanguage], in, translated)
+		} else {
+			dual = MergeDualVTT(in, translated)
+		}
+		if subtitleSuffix == "srt" {
+			dual = VttToSRT(dual)
+		}
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(DualPath(dest), []byte(dual), 0755)
+		if err != nil {
+			return err
+		}
+	}
 
 	// current subtitle is .ass, and we don't have .vtt translations to run
 	if convertToVTT && subtitleSuffix == "ass" &&
@@ -117,14 +209,29 @@ func Translate(media, inputDir, mediaFile, dest, languageWithCode, subtitleSuffi
 		if err != nil {
 			return err
 		}
+		if config.TheConfig.EnableDualSubtitles {
+			err = AssToVTT(DualPath(dest))
+			if err != nil {
+				return err
+			}
+		}
 	}
 	return nil
 }
 
-func TranslateSubtitlesASS(headers string, input []string, language, systemMessage string) (string, error) {
+// vttToSRTFile writes the WebVTT file source as SubRip to dest
+func vttToSRTFile(source, dest string) error {
+	content, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dest, []byte(VttToSRT(string(content))), 0755)
+}
+
+func TranslateSubtitlesASS(ctx context.Context, headers string, input []string, language, systemMessage string,
+	opts ...ai.ChatOption) (string, error) {
 	discord.Infof("[ASS] Translating to language: %s", language)
 
-	ctx := context.Background()
 	translated, err := ai.SendWithRetrySplit(ctx, systemMessage, input, func(input string, result ai.Result) bool {
 		t := removeEmptyLines(result.Text())
 		outputLines := len(t)
@@ -137,17 +244,17 @@ func TranslateSubtitlesASS(headers string, input []string, language, systemMessa
 		return len(strings.Split(input, "\n"))
 	}, func(input string) string {
 		return input
-	})
+	}, opts...)
 	if err != nil {
 		return "", err
 	}
 	return strings.Join(translated, "\n"), nil
 }
 
-func TranslateSubtitlesWebVTT(input []string, language, systemMessage string) (string, error) {
+func TranslateSubtitlesWebVTT(ctx context.Context, input []string, language, systemMessage string,
+	opts ...ai.ChatOption) (string, error) {
 	discord.Infof("[WEBVTT] Translating to language: %s", language)
 
-	ctx := context.Background()
 	translated, err := ai.SendWithRetrySplit(ctx, systemMessage, input, func(input string, result ai.Result) bool {
 		t := result.Text()
 		sanitized := sanitizeOutputVTT(t)
@@ -166,7 +273,7 @@ func TranslateSubtitlesWebVTT(input []string, language, systemMessage string) (s
 		return utils.CountVTTTimeLines(input)
 	}, func(input string) string {
 		return sanitizeOutputVTT(input)
-	})
+	}, opts...)
 	if err != nil {
 		return "", err
 	}
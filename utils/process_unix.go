@@ -0,0 +1,21 @@
+//go:build !windows
+
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// setProcessGroup starts the command in its own process group, so cancelling its context
+// kills every child it spawned instead of only the direct child.
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	if c.Cancel != nil {
+		c.Cancel = func() error {
+			return syscall.Kill(-c.Process.Pid, syscall.SIGKILL)
+		}
+		c.WaitDelay = 10 * time.Second
+	}
+}
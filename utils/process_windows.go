@@ -0,0 +1,16 @@
+//go:build windows
+
+package utils
+
+import (
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+func setProcessGroup(c *exec.Cmd) {
+	c.SysProcAttr = &syscall.SysProcAttr{CreationFlags: syscall.CREATE_NEW_PROCESS_GROUP}
+	if c.Cancel != nil {
+		c.WaitDelay = 10 * time.Second
+	}
+}
@@ -18,10 +18,12 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 )
 
-// WebvttTimeRangeRegex Matches lines like "00:00:01.000 --> 00:00:05.000", "00:01.000 --> 00:05.000"
-var WebvttTimeRangeRegex = regexp.MustCompile(`^((?:\d{1,2}:){0,2}\d{1,2}\.\d{1,3})(\s*-->\s*)((?:\d{1,2}:){0,2}\d{1,2}\.\d{1,3})$`)
+// WebvttTimeRangeRegex Matches lines like "00:00:01.000 --> 00:00:05.000", "00:01.000 --> 00:05.000",
+// optionally followed by cue settings like "line:0 align:start", captured with their leading space
+var WebvttTimeRangeRegex = regexp.MustCompile(`^((?:\d{1,2}:){0,2}\d{1,2}\.\d{1,3})(\s*-->\s*)((?:\d{1,2}:){0,2}\d{1,2}\.\d{1,3})((?:[ \t]+\S.*)?)$`)
 
 func IsWebVTTTimeRangeLine(input string) bool {
 	return WebvttTimeRangeRegex.MatchString(input)
@@ -62,7 +64,25 @@ func GetTitleId(title string) string {
 	return titleId + se
 }
 
+var seasonEpisodeSuffixRegex = regexp.MustCompile(`(?i)s\d{2}e\d{2}.*$`)
+
+// GetShowId returns the title id without the season and episode, shared by every episode of a show.
+// Titles without ASCII letters or digits, e.g. Japanese only ones, get a hash of the show's title instead.
+func GetShowId(title string) string {
+	id := seasonEpisodeSuffixRegex.ReplaceAllString(GetTitleId(title), "")
+	if id != "" {
+		return id
+	}
+	show := strings.TrimRight(seasonEpisodeSuffixRegex.ReplaceAllString(title, ""), " -")
+	if strings.TrimSpace(show) == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(show))))
+	return hex.EncodeToString(sum[:8])
+}
+
 func run(c *exec.Cmd) error {
+	setProcessGroup(c)
 	if err := c.Start(); err != nil {
 		return err
 	}
@@ -101,6 +121,61 @@ func RunCommand(cmd *exec.Cmd) ([]byte, error) {
 	return out, err
 }
 
+// RunCommandOutput runs cmd and returns its stdout only, for commands writing binary data there
+func RunCommandOutput(cmd *exec.Cmd) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := run(cmd)
+	if err != nil {
+		discord.Errorf(cmd.String())
+		fmt.Println(stderr.String())
+	}
+	return stdout.Bytes(), err
+}
+
+// lineWriter calls onLine for every line written to it, lines end with either \n or \r
+// since progress meters rewrite the same terminal line.
+type lineWriter struct {
+	onLine func(line string)
+	buf    []byte
+	mutex  sync.Mutex
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, c := range p {
+		if c == '\n' || c == '\r' {
+			if len(w.buf) > 0 {
+				w.onLine(string(w.buf))
+				w.buf = w.buf[:0]
+			}
+			continue
+		}
+		w.buf = append(w.buf, c)
+	}
+	return len(p), nil
+}
+
+// RunCommandWithProgress behaves like RunCommand, additionally streaming each output line to onLine while the command runs
+func RunCommandWithProgress(cmd *exec.Cmd, onLine func(line string)) ([]byte, error) {
+	if cmd.Stdout != nil || cmd.Stderr != nil {
+		return nil, fmt.Errorf("exec: Stdout or Stderr already set")
+	}
+	var b bytes.Buffer
+	w := io.MultiWriter(&b, &lineWriter{onLine: onLine})
+	cmd.Stdout = w
+	cmd.Stderr = w
+	err := run(cmd)
+	out := b.Bytes()
+	if err != nil {
+		discord.Errorf(cmd.String())
+		fmt.Println(string(out))
+	}
+	return out, err
+}
+
 func RandomString(length int) string {
 	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
 	b := make([]byte, length)